@@ -0,0 +1,859 @@
+// Archive level API: compression and decompression of whole streams.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Stats describes the result of a compression run.
+type Stats struct {
+	OriginalSize   uint64        // number of source bytes
+	CompressedSize uint64        // number of archive bytes, dictionary included
+	DictionarySize uint64        // number of bytes taken by the dictionary
+	Symbols        int           // number of distinct symbols in the source
+	Stored         bool          // source is kept as is, as it does not compress
+	Expanded       bool          // archive is larger than the source
+	CRC32          uint32        // IEEE CRC-32 of the source, see Options.CRC32
+	Elapsed        time.Duration // wall time of the whole operation
+}
+
+// Ratio returns the compressed to original size ratio.
+func (s Stats) Ratio() float64 {
+	if s.OriginalSize == 0 {
+		return 0
+	}
+	return float64(s.CompressedSize) / float64(s.OriginalSize)
+}
+
+// countingWriter counts the bytes passed to the underlying io.Writer.
+type countingWriter struct {
+	out io.Writer
+	n   uint64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.out.Write(p)
+	w.n += uint64(n)
+	return
+}
+
+// limitWriter passes at most n more bytes to the underlying io.Writer
+// and fails with ErrOutputTooLarge on any more.
+type limitWriter struct {
+	out io.Writer
+	n   uint64
+}
+
+func (w *limitWriter) Write(p []byte) (n int, err error) {
+	if uint64(len(p)) > w.n {
+		return 0, ErrOutputTooLarge
+	}
+	n, err = w.out.Write(p)
+	w.n -= uint64(n)
+	return
+}
+
+// sliceWriter fills buf, failing with ErrShortBuffer once it is full.
+type sliceWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *sliceWriter) Write(p []byte) (n int, err error) {
+	n = copy(w.buf[w.n:], p)
+	w.n += n
+	if n < len(p) {
+		return n, ErrShortBuffer
+	}
+	return n, nil
+}
+
+// Compress reads src until EOF and writes the archive to dst.
+func Compress(src io.Reader, dst io.Writer) error {
+	_, err := CompressWithStats(src, dst)
+	return err
+}
+
+// CompressWithStats is like Compress, but also reports statistics of the run.
+func CompressWithStats(src io.Reader, dst io.Writer) (Stats, error) {
+	return CompressWithOptions(context.Background(), src, dst, Options{})
+}
+
+// CompressContext is like Compress, but stops early with ctx.Err()
+// once the context is done.
+func CompressContext(ctx context.Context, src io.Reader, dst io.Writer) error {
+	_, err := CompressWithOptions(ctx, src, dst, Options{})
+	return err
+}
+
+// CompressWithOptions is like CompressContext, but configured by opts
+// and also reports statistics of the run.
+// Source is read into memory as it has to be scanned before it is encoded,
+// unless it is an io.ReadSeeker: then it is read twice, rewinding it in between.
+// Block mode, RLE, words mode, Store, StaticTable, Permute and VerifyAfterWrite need
+// the whole source in memory anyway, but StaticTable with SizeFooter
+// reads the source only once, as it is encoded.
+func CompressWithOptions(ctx context.Context, src io.Reader, dst io.Writer, opts Options) (Stats, error) {
+	start := time.Now()
+
+	if err := opts.validate(); err != nil {
+		return Stats{}, err
+	}
+
+	seeker, seekable, err := sourceSeeker(src)
+	if err != nil {
+		return Stats{}, err
+	}
+	rewind := seekable && opts.BlockSize == 0 && !opts.RLE && !opts.Words && !opts.Store && opts.StaticTable == 0 && !opts.Permute && !opts.VerifyAfterWrite
+	// Nothing needs to know the source in advance.
+	streaming := opts.SizeFooter && opts.StaticTable != 0 && !opts.VerifyAfterWrite
+
+	var data []byte
+	if !rewind && !streaming {
+		if data, err = io.ReadAll(src); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	flags := opts.byteOrderFlags()
+	if opts.BlockSize > 0 {
+		flags |= flagBlocks
+	}
+	if opts.RLE {
+		flags |= flagRLE
+	}
+	if opts.Words {
+		flags |= flagWords
+	}
+	if opts.Passphrase != "" {
+		flags |= flagEncrypted
+		if opts.Authenticate {
+			flags |= flagAuthenticated
+		}
+	}
+	if opts.Digest {
+		flags |= flagDigest
+	}
+	if opts.BitCount {
+		flags |= flagBitCount
+	}
+	if opts.Name != "" {
+		flags |= flagName
+	}
+	if opts.StaticTable != 0 {
+		flags |= flagStatic
+	}
+	if opts.SizeFooter {
+		flags |= flagFooter
+	}
+	if opts.Permute {
+		flags |= flagPermuted
+	}
+
+	// Whether the source compresses is only known once it is encoded.
+	var stats Stats
+	var encoded bytes.Buffer
+	if opts.Store && flags&flagBlocks == 0 {
+		if stats, err = encode(ctx, data, &encoded, flags, opts); err != nil {
+			return Stats{}, err
+		}
+		if uint64(encoded.Len()) > 8+uint64(len(data)) {
+			flags = flags&^(flagRLE|flagWords|flagStatic|flagPermuted) | flagStored
+			stats.DictionarySize = 0
+			stats.Stored = true
+		}
+	}
+
+	var written bytes.Buffer
+	if opts.VerifyAfterWrite {
+		dst = io.MultiWriter(dst, &written)
+	}
+
+	out := &countingWriter{out: dst}
+	hash := sha256.New()
+	if flags&flagDigest != 0 {
+		out.out = io.MultiWriter(dst, hash)
+	}
+	if err = writeHeader(flags, out); err != nil {
+		return Stats{}, err
+	}
+	if flags&flagName != 0 {
+		if err = writeName(opts.Name, byteOrder(flags), out); err != nil {
+			return Stats{}, err
+		}
+	}
+	if flags&flagStatic != 0 {
+		if err = writeStatic(opts.StaticTable, out); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	var body io.Writer = out
+	var encrypter io.WriteCloser
+	if flags&flagEncrypted != 0 {
+		if encrypter, err = newEncrypter(flags, opts.Passphrase, out); err != nil {
+			return Stats{}, err
+		}
+		body = encrypter
+	}
+
+	switch {
+	case flags&flagBlocks != 0:
+		stats, err = writeBlocks(ctx, data, body, flags, opts)
+	case flags&flagStored != 0:
+		err = writeStored(data, byteOrder(flags), body)
+	case opts.Store:
+		_, err = body.Write(encoded.Bytes())
+	case rewind:
+		stats, err = encodeSeeker(ctx, seeker, body, flags, opts)
+	case streaming:
+		stats, err = encodeStaticStream(ctx, src, body, flags, opts)
+	default:
+		stats, err = encode(ctx, data, body, flags, opts)
+	}
+	if err != nil {
+		return Stats{}, err
+	}
+	if encrypter != nil {
+		if err = encrypter.Close(); err != nil {
+			return Stats{}, err
+		}
+	}
+	if flags&flagDigest != 0 {
+		if _, err = out.Write(hash.Sum(nil)); err != nil {
+			return Stats{}, err
+		}
+	}
+	if opts.VerifyAfterWrite {
+		if err = verify(ctx, written.Bytes(), data, opts); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	if !rewind && !streaming {
+		stats.CRC32 = crc32.ChecksumIEEE(data)
+	}
+	stats.CompressedSize = out.n
+	stats.Expanded = stats.CompressedSize > stats.OriginalSize
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// verify decompresses the archive and checks that it gives back data.
+func verify(ctx context.Context, archive []byte, data []byte, opts Options) error {
+	opts.Progress = nil
+	check := &compareWriter{want: data}
+	if _, err := DecompressWithOptions(ctx, bytes.NewReader(archive), check, opts); err != nil {
+		if err == ctx.Err() {
+			return err
+		}
+		return ErrVerifyFailed
+	}
+	if len(check.want) > 0 {
+		return ErrVerifyFailed
+	}
+	return nil
+}
+
+// compareWriter checks that the data written to it matches want,
+// leaving the part of want not written yet.
+type compareWriter struct {
+	want []byte
+}
+
+func (w *compareWriter) Write(p []byte) (n int, err error) {
+	if len(p) > len(w.want) || !bytes.Equal(p, w.want[:len(p)]) {
+		return 0, ErrVerifyFailed
+	}
+	w.want = w.want[len(p):]
+	return len(p), nil
+}
+
+// encode writes data as a single stream: dictionary, size and payload,
+// applying the pre-passes selected by flags.
+// Returned stats have the OriginalSize, DictionarySize and Symbols filled in.
+func encode(ctx context.Context, data []byte, dst io.Writer, flags uint32, opts Options) (Stats, error) {
+	source := data
+	tableSize := 0
+	if flags&flagPermuted != 0 {
+		forward, ranked := permutation(data)
+		if err := writePermutation(ranked, byteOrder(flags), dst); err != nil {
+			return Stats{}, err
+		}
+		source = permute(data, &forward)
+		tableSize = 2 + len(ranked)
+	}
+	if flags&flagRLE != 0 {
+		source = rleEncode(source)
+	}
+
+	if flags&flagStatic != 0 {
+		return encodeStatic(ctx, data, source, dst, flags, opts)
+	}
+
+	wide := flags&flagWords != 0
+
+	sample := source
+	if opts.SampleSize > 0 && opts.SampleSize < len(source) {
+		sample = source[:opts.SampleSize]
+	}
+
+	var leafs []*Leaf
+	var err error
+	if wide {
+		leafs, err = scanWords(ctx, sample, opts)
+	} else {
+		leafs, err = scanParallel(ctx, bytes.NewReader(sample), int64(len(sample)), opts)
+	}
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(sample) < len(source) {
+		leafs = addMissing(leafs, wide)
+	}
+
+	writer := NewWriter(dst)
+	dict, dictSize, err := encodeDictionary(leafs, wide, byteOrder(flags), writer, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if err = encodePayload(ctx, dict, source, flags, writer, opts); err != nil {
+		return Stats{}, err
+	}
+
+	return Stats{
+		OriginalSize:   uint64(len(data)),
+		DictionarySize: uint64(tableSize + dictSize),
+		Symbols:        len(leafs),
+	}, nil
+}
+
+// encodeStatic is like encode, but codes source by the static table
+// of opts instead of writing a dictionary.
+func encodeStatic(ctx context.Context, data []byte, source []byte, dst io.Writer, flags uint32, opts Options) (Stats, error) {
+	_, dict, err := staticTree(opts.StaticTable)
+	if err != nil {
+		return Stats{}, err
+	}
+	if err = encodePayload(ctx, dict, source, flags, NewWriter(dst), opts); err != nil {
+		return Stats{}, err
+	}
+
+	var symbols [256]bool
+	stats := Stats{OriginalSize: uint64(len(data))}
+	for _, value := range source {
+		if !symbols[value] {
+			symbols[value] = true
+			stats.Symbols++
+		}
+	}
+	return stats, nil
+}
+
+// encodeStaticStream is like encodeStatic with the size footer,
+// but encodes src as it reads it until EOF.
+// Returned stats have the OriginalSize and CRC32 filled in.
+func encodeStaticStream(ctx context.Context, src io.Reader, dst io.Writer, flags uint32, opts Options) (Stats, error) {
+	_, dict, err := staticTree(opts.StaticTable)
+	if err != nil {
+		return Stats{}, err
+	}
+	source := &crcReader{in: src}
+	if err = encodeStream(ctx, dict, 0, source, flags, NewWriter(dst), opts); err != nil {
+		return Stats{}, err
+	}
+	return Stats{OriginalSize: source.n, CRC32: source.crc}, nil
+}
+
+// encodeDictionary builds the codes of leafs and writes them as the
+// dictionary of the version selected by opts.
+// Returns the codes and the size of the dictionary.
+func encodeDictionary(leafs []*Leaf, wide bool, order binary.ByteOrder, writer Writer, opts Options) ([][]bool, int, error) {
+	version := opts.dictionaryVersion()
+	if version == 1 {
+		scaleFrequencies(leafs, math.MaxUint32)
+	}
+
+	// A word mode source shorter than a word has no symbols at all.
+	dict := make([][]bool, alphabet(wide))
+	if len(leafs) > 0 {
+		tree, err := BuildTree(leafs)
+		if err != nil {
+			return nil, 0, err
+		}
+		dict = flatTree(tree, leafs, wide)
+	}
+
+	dictSize, err := writeDictionary(version, leafs, dict, wide, order, writer)
+	if err != nil {
+		return nil, 0, err
+	}
+	return dict, dictSize, nil
+}
+
+// sourceSeeker returns src as an io.ReadSeeker if it can be rewound.
+// A file which is not a regular one, like a pipe or a device, cannot be
+// measured by seeking, so it is read as a plain stream, and a directory
+// is refused with ErrNotRegularFile.
+func sourceSeeker(src io.Reader) (io.ReadSeeker, bool, error) {
+	if file, ok := src.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, false, err
+		}
+		if info.IsDir() {
+			return nil, false, ErrNotRegularFile
+		}
+		if !info.Mode().IsRegular() {
+			return nil, false, nil
+		}
+	}
+	seeker, ok := src.(io.ReadSeeker)
+	return seeker, ok, nil
+}
+
+// encodeSeeker is like encode without RLE and words mode, but reads src
+// from its current offset twice, rewinding it in between,
+// instead of keeping it in memory. A source which is also an io.ReaderAt,
+// like *os.File and *bytes.Reader, is counted by opts.Workers goroutines.
+func encodeSeeker(ctx context.Context, src io.ReadSeeker, dst io.Writer, flags uint32, opts Options) (Stats, error) {
+	start, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Stats{}, err
+	}
+	end, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Stats{}, err
+	}
+	size := end - start
+	if _, err = src.Seek(start, io.SeekStart); err != nil {
+		return Stats{}, err
+	}
+
+	sampled := opts.SampleSize > 0 && int64(opts.SampleSize) < size
+	scanned := size
+	if sampled {
+		scanned = int64(opts.SampleSize)
+	}
+	var leafs []*Leaf
+	if at, ok := src.(io.ReaderAt); ok {
+		leafs, err = scanParallel(ctx, io.NewSectionReader(at, start, scanned), scanned, opts)
+	} else {
+		var freqs [256]uint64
+		err = count(ctx, io.LimitReader(src, scanned), &freqs, opts)
+		leafs = leavesOf(freqs[:])
+	}
+	if err != nil {
+		return Stats{}, err
+	}
+	if sampled {
+		leafs = addMissing(leafs, false)
+	}
+
+	writer := NewWriter(dst)
+	dict, dictSize, err := encodeDictionary(leafs, false, byteOrder(flags), writer, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if _, err = src.Seek(start, io.SeekStart); err != nil {
+		return Stats{}, err
+	}
+	// The source may have grown since it was scanned, the rest is left out.
+	source := &crcReader{in: io.LimitReader(src, size)}
+	if err = encodeStream(ctx, dict, uint64(size), source, flags, writer, opts); err != nil {
+		return Stats{}, err
+	}
+
+	return Stats{
+		OriginalSize:   uint64(size),
+		DictionarySize: uint64(dictSize),
+		Symbols:        len(leafs),
+		CRC32:          source.crc,
+	}, nil
+}
+
+// EstimateSize reads src until EOF and returns the size of the archive
+// Compress would write, without encoding the payload.
+func EstimateSize(src io.Reader) (uint64, error) {
+	opts := Options{}
+	leafs, err := scan(context.Background(), src, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	dict := make([][]bool, alphabet(false))
+	if len(leafs) > 0 {
+		tree, err := BuildTree(leafs)
+		if err != nil {
+			return 0, err
+		}
+		dict = flatTree(tree, leafs, false)
+	}
+
+	// The dictionary is small, so it is cheaper to write it than to predict its size.
+	dictSize, err := writeDictionary(opts.dictionaryVersion(), leafs, dict, false, binary.BigEndian, NewWriter(io.Discard))
+	if err != nil {
+		return 0, err
+	}
+
+	var bits uint64
+	for _, leaf := range leafs {
+		bits += leaf.Frequency * uint64(len(dict[leaf.Value]))
+	}
+	return uint64(headerSize+dictSize+8) + (bits+7)/8, nil
+}
+
+// WillFitInBudget reads src until EOF and reports whether the archive
+// Compress would write takes at most budget bytes, along with its size
+// as returned by EstimateSize.
+func WillFitInBudget(src io.Reader, budget uint64) (bool, uint64, error) {
+	size, err := EstimateSize(src)
+	if err != nil {
+		return false, 0, err
+	}
+	return size <= budget, size, nil
+}
+
+// encodePayload writes the size of source followed by the payload coded by dict,
+// and closes the writer.
+func encodePayload(ctx context.Context, dict [][]bool, source []byte, flags uint32, writer Writer, opts Options) error {
+	if flags&flagWords == 0 {
+		return encodeStream(ctx, dict, uint64(len(source)), &crcReader{in: bytes.NewReader(source)}, flags, writer, opts)
+	}
+
+	if err := writeFileSize(uint64(len(source)), byteOrder(flags), writer); err != nil {
+		return err
+	}
+	size, err := compressWords(ctx, dict, source, writer, opts)
+	if err != nil {
+		return err
+	}
+	if err = endPayload(size, flags, writer); err != nil {
+		return err
+	}
+	// The odd byte left over by word mode is stored as is.
+	if len(source)%2 == 1 {
+		if err = writer.WriteByte(source[len(source)-1]); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// encodeStream is like encodePayload out of words mode, but reads the size
+// bytes of source from src. With the size footer, size may be 0 if it is not
+// known, then src is read until EOF.
+func encodeStream(ctx context.Context, dict [][]bool, size uint64, src *crcReader, flags uint32, writer Writer, opts Options) error {
+	footer := flags&flagFooter != 0
+	if !footer {
+		if err := writeFileSize(size, byteOrder(flags), writer); err != nil {
+			return err
+		}
+	}
+	payload, err := compress(ctx, dict, size, NewReader(src), writer, opts)
+	if err != nil {
+		return err
+	}
+	if err = endPayload(payload, flags, writer); err != nil {
+		return err
+	}
+	if footer {
+		if err = writeFooter(src.n, src.crc, byteOrder(flags), writer); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// endPayload aligns the writer past a payload of size bytes
+// and writes the valid bit count if flags ask for it.
+func endPayload(size uint64, flags uint32, writer Writer) error {
+	skipped, err := writer.Align()
+	if err != nil {
+		return err
+	}
+	if flags&flagBitCount != 0 {
+		return writer.WriteByte(validBits(size, skipped))
+	}
+	return nil
+}
+
+// validBits returns the number of valid bits in the last byte of a payload
+// of size bytes, aligned by skipping the given number of bits:
+// 1 to 8, or 0 for an empty payload.
+func validBits(size uint64, skipped byte) byte {
+	if size == 0 {
+		return 0
+	}
+	return 8 - skipped
+}
+
+// Decompress reads the archive from src and writes the original data to dst.
+func Decompress(src io.Reader, dst io.Writer) error {
+	_, err := DecompressWithStats(src, dst)
+	return err
+}
+
+// DecompressWithStats is like Decompress, but also reports statistics of the run.
+// Only OriginalSize, CRC32 and Elapsed are filled in.
+func DecompressWithStats(src io.Reader, dst io.Writer) (Stats, error) {
+	return DecompressWithOptions(context.Background(), src, dst, Options{})
+}
+
+// DecompressContext is like Decompress, but stops early with ctx.Err()
+// once the context is done.
+func DecompressContext(ctx context.Context, src io.Reader, dst io.Writer) error {
+	_, err := DecompressWithOptions(ctx, src, dst, Options{})
+	return err
+}
+
+// DecompressWithOptions is like DecompressContext, but configured by opts
+// and also reports statistics of the run.
+func DecompressWithOptions(ctx context.Context, src io.Reader, dst io.Writer, opts Options) (Stats, error) {
+	start := time.Now()
+
+	if err := opts.validate(); err != nil {
+		return Stats{}, err
+	}
+
+	in, ok := src.(*bufio.Reader)
+	if !ok {
+		in = bufio.NewReader(src)
+	}
+
+	// A legacy archive has no header, which is as good as no flags.
+	var flags uint32
+	var err error
+	if !opts.Legacy {
+		if flags, err = readHeader(in); err != nil {
+			return Stats{}, err
+		}
+	}
+	if flags&flagFiles != 0 {
+		return Stats{}, ErrFilesArchive
+	}
+	if flags&flagShared != 0 {
+		return Stats{}, ErrManyArchive
+	}
+	if flags&flagExternal != 0 {
+		return Stats{}, ErrDictionaryRequired
+	}
+	name, err := readName(in, flags)
+	if err != nil {
+		return Stats{}, err
+	}
+	_, static, err := readStatic(in, flags)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var body io.Reader = in
+	if flags&flagEncrypted != 0 {
+		if body, err = newDecrypter(flags, opts.Passphrase, in); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	// The size follows the payload, so the rest of the archive is read to find it.
+	var footerCRC uint32
+	if flags&flagFooter != 0 {
+		stream, err := io.ReadAll(body)
+		if err != nil {
+			return Stats{}, err
+		}
+		// The digest is read along, but by authenticated encryption.
+		if flags&flagDigest != 0 && flags&flagAuthenticated == 0 {
+			if len(stream) < sha256.Size {
+				return Stats{}, io.ErrUnexpectedEOF
+			}
+			stream = stream[:len(stream)-sha256.Size]
+		}
+		if stream, footerCRC, err = footerStream(stream, flags); err != nil {
+			return Stats{}, err
+		}
+		body = bytes.NewReader(stream)
+	}
+
+	if opts.MaxOutputSize > 0 {
+		dst = &limitWriter{out: dst, n: opts.MaxOutputSize}
+	}
+	checksum := &crcWriter{out: dst}
+	dst = checksum
+
+	reader := NewReader(body)
+
+	var size uint64
+	switch {
+	case flags&flagBlocks != 0:
+		size, err = readBlocks(ctx, reader, dst, flags, name, opts)
+	case flags&flagStored != 0:
+		size, err = readStored(reader, byteOrder(flags), dst)
+	case flags&flagStatic != 0:
+		size, err = decodePayload(ctx, static, reader, dst, flags, opts)
+	default:
+		size, err = decode(ctx, reader, dst, flags, opts)
+	}
+	if err != nil {
+		return Stats{}, err
+	}
+	if opts.CRC32 != nil && checksum.crc != *opts.CRC32 {
+		return Stats{}, ErrChecksumMismatch
+	}
+	if flags&flagFooter != 0 && checksum.crc != footerCRC {
+		return Stats{}, ErrChecksumMismatch
+	}
+
+	return Stats{
+		OriginalSize: size,
+		CRC32:        checksum.crc,
+		Elapsed:      time.Since(start),
+	}, nil
+}
+
+// DecompressInto decompresses the archive into dst and returns the number
+// of bytes written, failing with ErrShortBuffer if dst is too small.
+// ReadInfo tells the size of dst needed, but for run-length encoded archives.
+func DecompressInto(archive []byte, dst []byte) (int, error) {
+	out := &sliceWriter{buf: dst}
+	_, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive), out, Options{})
+	return out.n, err
+}
+
+// Validate decodes the archive like Decompress, discarding the output,
+// to check that it can be decompressed without writing it anywhere.
+// An archive with a digest is also checked against it, like by Verify,
+// as it is read.
+func Validate(archive io.Reader) error {
+	hash := sha256.New()
+	footer := &tailWriter{out: hash, size: sha256.Size}
+	in := bufio.NewReader(io.TeeReader(archive, footer))
+	header, _ := in.Peek(headerSize)
+	digest := len(header) == headerSize && bytes.Equal(header[:len(magic)], magic[:]) &&
+		binary.BigEndian.Uint32(header[len(magic):])&flagDigest != 0
+
+	if _, err := DecompressWithOptions(context.Background(), in, io.Discard, Options{}); err != nil {
+		return err
+	}
+	if !digest {
+		return nil
+	}
+
+	// The digest covers everything before it, the part of the archive
+	// decompression did not need included.
+	if _, err := io.Copy(io.Discard, in); err != nil {
+		return err
+	}
+	if len(footer.tail) < sha256.Size {
+		return io.ErrUnexpectedEOF
+	}
+	if !bytes.Equal(hash.Sum(nil), footer.tail) {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// decode reads a single stream written by encode and returns the number
+// of bytes written to dst. The reader is left aligned past the payload.
+func decode(ctx context.Context, reader Reader, dst io.Writer, flags uint32, opts Options) (uint64, error) {
+	if flags&flagPermuted != 0 {
+		inverse, err := readPermutation(reader, byteOrder(flags))
+		if err != nil {
+			return 0, err
+		}
+		dst = &permuteWriter{out: dst, table: inverse}
+	}
+	tree, err := readDictionary(reader, flags&flagWords != 0, byteOrder(flags))
+	if err != nil {
+		return 0, err
+	}
+	return decodePayload(ctx, tree, reader, dst, flags, opts)
+}
+
+// decodePayload reads the size and the payload of a stream coded by tree
+// and returns the number of bytes written to dst.
+func decodePayload(ctx context.Context, tree *Leaf, reader Reader, dst io.Writer, flags uint32, opts Options) (uint64, error) {
+	out := &countingWriter{out: dst}
+	var target io.Writer = out
+	if flags&flagRLE != 0 {
+		target = &rleWriter{out: out}
+	}
+	writer := NewWriter(target)
+
+	wide := flags&flagWords != 0
+
+	size, err := readFileSize(reader, byteOrder(flags))
+	if err != nil {
+		return 0, &DecodeError{Phase: PhaseHeader, Offset: reader.BitsRead(), Err: err}
+	}
+	// Run-length encoded size says little about the output size,
+	// which limitWriter checks anyway.
+	if opts.MaxOutputSize > 0 && flags&flagRLE == 0 && size > opts.MaxOutputSize {
+		return 0, ErrOutputTooLarge
+	}
+
+	symbols := size
+	if wide {
+		symbols = size / 2
+	}
+	// Dictionaries are read with a lone symbol below a root, so a tree
+	// without children is of no symbols and cannot code any.
+	if symbols > 0 && tree.Zero == nil && tree.One == nil {
+		return 0, &DecodeError{Phase: PhasePayload, Offset: reader.BitsRead(), Err: ErrCorruptPayload}
+	}
+	payload := reader.BitsRead()
+	if _, err = decompress(ctx, tree, symbols, wide, reader, writer, opts); err != nil {
+		return 0, err
+	}
+	skipped, err := reader.Align()
+	if err != nil {
+		return 0, err
+	}
+	if flags&flagBitCount != 0 {
+		if err = checkBitCount(reader, (reader.BitsRead()-payload)/8, skipped); err != nil {
+			return 0, &DecodeError{Phase: PhasePayload, Offset: reader.BitsRead(), Err: err}
+		}
+	}
+
+	if wide && size%2 == 1 {
+		value, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if err = writer.WriteByte(value); err != nil {
+			return 0, err
+		}
+		if err = writer.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	return out.n, nil
+}
+
+// checkBitCount reads the number of valid bits following a payload
+// of size bytes, aligned by skipping the given number of bits,
+// and checks that it matches the payload. The payload is decoded by
+// the source size, the count is only checked, never decoded up to.
+func checkBitCount(reader Reader, size uint64, skipped byte) error {
+	valid, err := reader.ReadByte()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	if valid != validBits(size, skipped) {
+		return ErrCorruptPayload
+	}
+	return nil
+}
@@ -0,0 +1,999 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+// sampleText returns n bytes of English-like text, the same on every run.
+func sampleText(n int) []byte {
+	words := []string{
+		"the", "quick", "brown", "fox", "jumps", "over", "a", "lazy", "dog",
+		"and", "runs", "into", "forest", "where", "it", "finds", "nothing",
+	}
+	r := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for buf.Len() < n {
+		buf.WriteString(words[r.Intn(len(words))])
+		if r.Intn(12) == 0 {
+			buf.WriteString(".\n")
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.Bytes()[:n]
+}
+
+// randomBytes returns n pseudo-random bytes, the same on every run.
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(2)).Read(data)
+	return data
+}
+
+// roundTrip compresses in by opts, checks that decompressing the archive
+// gives it back and returns the archive.
+func roundTrip(t *testing.T, in []byte, opts Options) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &archive, opts); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	var out bytes.Buffer
+	if _, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive.Bytes()), &out, opts); err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), in) {
+		t.Fatalf("round trip gave %d bytes, want %d", out.Len(), len(in))
+	}
+	return archive.Bytes()
+}
+
+// cancelHalfway returns a context and options which cancel it
+// once progress passes half the total.
+func cancelHalfway() (context.Context, Options) {
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := Options{BufferSize: 1024, Progress: func(processed, total uint64) {
+		if processed > total/2 {
+			cancel()
+		}
+	}}
+	return ctx, opts
+}
+
+func TestCompressCancel(t *testing.T) {
+	in := sampleText(64 << 10)
+	// A plain reader is scanned from memory, a bytes.Reader twice from the source.
+	for _, src := range []io.Reader{bytes.NewBuffer(in), bytes.NewReader(in)} {
+		ctx, opts := cancelHalfway()
+		_, err := CompressWithOptions(ctx, src, new(bytes.Buffer), opts)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%T: got %v, want context.Canceled", src, err)
+		}
+	}
+}
+
+func TestDecompressCancel(t *testing.T) {
+	archive := roundTrip(t, sampleText(64<<10), Options{})
+	ctx, opts := cancelHalfway()
+	var out bytes.Buffer
+	_, err := DecompressWithOptions(ctx, bytes.NewReader(archive), &out, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if out.Len() == 0 || out.Len() == 64<<10 {
+		t.Fatalf("cancelled after %d bytes, want mid-stream", out.Len())
+	}
+}
+
+func BenchmarkBufferSize(b *testing.B) {
+	in := sampleText(16 << 20)
+	path := filepath.Join(b.TempDir(), "source")
+	if err := os.WriteFile(path, in, 0644); err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []int{4 << 10, 1 << 20} {
+		b.Run(strconv.Itoa(size>>10)+"K", func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				src, err := os.Open(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = CompressWithOptions(context.Background(), src, io.Discard, Options{BufferSize: size})
+				src.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDictionaryVersion1(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":  nil,
+		"single": bytes.Repeat([]byte{'x'}, 100),
+		"text":   sampleText(10000),
+		"random": randomBytes(10000),
+	}
+	for name, in := range inputs {
+		archive := roundTrip(t, in, Options{DictionaryVersion: 1})
+		if len(in) == 0 {
+			continue
+		}
+		if version := binary.BigEndian.Uint16(archive[headerSize:]); version != 1 {
+			t.Errorf("%s: dictionary version %d, want 1", name, version)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 10001} {
+		roundTrip(t, sampleText(n), Options{Words: true})
+	}
+	roundTrip(t, randomBytes(5000), Options{Words: true})
+}
+
+func BenchmarkWords(b *testing.B) {
+	in := sampleText(1 << 20)
+	for _, words := range []bool{false, true} {
+		name := "bytes"
+		if words {
+			name = "words"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			var stats Stats
+			for i := 0; i < b.N; i++ {
+				var err error
+				stats, err = CompressWithOptions(context.Background(), bytes.NewReader(in), io.Discard, Options{Words: words})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(stats.Ratio(), "ratio")
+		})
+	}
+}
+
+// benchmarkFixtures returns the inputs compression is benchmarked on.
+func benchmarkFixtures() []struct {
+	name string
+	data []byte
+} {
+	r := rand.New(rand.NewSource(3))
+	var records bytes.Buffer
+	records.WriteByte('[')
+	for i := 0; records.Len() < 1<<20; i++ {
+		if i > 0 {
+			records.WriteString(",\n")
+		}
+		records.WriteString(`{"id": ` + strconv.Itoa(i) + `, "name": "bee` + strconv.Itoa(r.Intn(1000)) +
+			`", "score": ` + strconv.FormatFloat(r.Float64(), 'f', 3, 64) + `, "active": ` + strconv.FormatBool(r.Intn(2) == 0) + `}`)
+	}
+	records.WriteByte(']')
+
+	// Little endian integers of a slow random walk, like samples or counters.
+	var samples []byte
+	var value int32
+	for len(samples) < 1<<20 {
+		value += int32(r.Intn(64)) - 32
+		samples = binary.LittleEndian.AppendUint32(samples, uint32(value))
+	}
+
+	return []struct {
+		name string
+		data []byte
+	}{
+		{"text", sampleText(1 << 20)},
+		{"json", records.Bytes()},
+		{"binary", samples},
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	for _, fixture := range benchmarkFixtures() {
+		b.Run(fixture.name, func(b *testing.B) {
+			b.SetBytes(int64(len(fixture.data)))
+			var stats Stats
+			for i := 0; i < b.N; i++ {
+				var err error
+				stats, err = CompressWithOptions(context.Background(), bytes.NewReader(fixture.data), io.Discard, Options{})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(stats.Ratio(), "ratio")
+		})
+	}
+}
+
+// BenchmarkFlate is the baseline of BenchmarkCompress:
+// compress/flate at the default level over the same inputs.
+func BenchmarkFlate(b *testing.B) {
+	for _, fixture := range benchmarkFixtures() {
+		b.Run(fixture.name, func(b *testing.B) {
+			b.SetBytes(int64(len(fixture.data)))
+			out := &countingWriter{out: io.Discard}
+			for i := 0; i < b.N; i++ {
+				out.n = 0
+				writer, err := flate.NewWriter(out, flate.DefaultCompression)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err = writer.Write(fixture.data); err != nil {
+					b.Fatal(err)
+				}
+				if err = writer.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(out.n)/float64(len(fixture.data)), "ratio")
+		})
+	}
+}
+
+// seedArchives returns archives of every kind, one for each header flag at least.
+func seedArchives(tb testing.TB) [][]byte {
+	tb.Helper()
+	text := sampleText(300)
+	var archives [][]byte
+	add := func(in []byte, opts Options) {
+		var archive bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &archive, opts); err != nil {
+			tb.Fatal(err)
+		}
+		archives = append(archives, archive.Bytes())
+	}
+	for _, in := range [][]byte{nil, []byte("a"), []byte("aaaa"), text} {
+		add(in, Options{})
+	}
+	for _, opts := range []Options{
+		{DictionaryVersion: 1},
+		{BlockSize: 100},
+		{BlockSize: 100, Chunking: true},
+		{RLE: true},
+		{Words: true},
+		{Passphrase: "secret"},
+		{Passphrase: "secret", Authenticate: true},
+		{Digest: true},
+		{ByteOrder: binary.LittleEndian},
+		{BitCount: true},
+		{Name: "name.txt"},
+		{StaticTable: StaticEnglish},
+		{SizeFooter: true},
+		{StaticTable: StaticEnglish, SizeFooter: true},
+		{Permute: true},
+	} {
+		add(text, opts)
+	}
+	add(randomBytes(300), Options{Store: true})
+
+	// Headerless legacy archive.
+	archives = append(archives, archives[3][headerSize:])
+
+	var external bytes.Buffer
+	_, _, dict := treeOf(tb, text)
+	var table [256][]bool
+	copy(table[:], dict)
+	if err := CompressWithDictionary(bytes.NewReader(text), &external, table); err != nil {
+		tb.Fatal(err)
+	}
+	archives = append(archives, external.Bytes())
+
+	var many bytes.Buffer
+	if err := CompressMany([]io.Reader{bytes.NewReader(text), bytes.NewReader(text[:100])}, &many); err != nil {
+		tb.Fatal(err)
+	}
+	archives = append(archives, many.Bytes())
+
+	dir := tb.TempDir()
+	var files []string
+	for i, in := range [][]byte{text, text[:50]} {
+		path := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.WriteFile(path, in, 0644); err != nil {
+			tb.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	for _, opts := range []Options{{}, {SharedDictionary: true}} {
+		var archive bytes.Buffer
+		if _, err := CompressFiles(context.Background(), &archive, files, opts); err != nil {
+			tb.Fatal(err)
+		}
+		archives = append(archives, archive.Bytes())
+	}
+	return archives
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, archive := range seedArchives(f) {
+		f.Add(archive)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Errors are expected, the decoders must only not panic or hang.
+		for _, opts := range []Options{{}, {Passphrase: "secret"}, {Legacy: true}} {
+			opts.MaxOutputSize = 1 << 20
+			DecompressWithOptions(context.Background(), bytes.NewReader(data), io.Discard, opts)
+		}
+		DecompressBlock(bytes.NewReader(data), 0, io.Discard)
+		Recover(bytes.NewReader(data), io.Discard)
+		ReadInfo(bytes.NewReader(data), int64(len(data)))
+		if entries, err := ReadEntries(bytes.NewReader(data), int64(len(data))); err == nil && len(entries) > 0 {
+			DecompressEntry(bytes.NewReader(data), entries[0], io.Discard)
+		}
+		DecompressMany(bytes.NewReader(data), []io.Writer{io.Discard, io.Discard})
+		if d, err := NewDecoder(bytes.NewReader(data)); err == nil {
+			for i := 0; i < 1<<20; i++ {
+				if _, err := d.NextSymbol(); err != nil {
+					break
+				}
+			}
+		}
+	})
+}
+
+func TestEstimateSize(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":  nil,
+		"single": []byte("x"),
+		"text":   sampleText(30000),
+		"random": randomBytes(30000),
+		"all":    allBytes(),
+	}
+	for name, in := range inputs {
+		var archive bytes.Buffer
+		if err := Compress(bytes.NewReader(in), &archive); err != nil {
+			t.Fatal(err)
+		}
+		estimate, err := EstimateSize(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate != uint64(archive.Len()) {
+			t.Errorf("%s: estimated %d bytes, compressed to %d", name, estimate, archive.Len())
+		}
+	}
+}
+
+// corruptingWriter flips a bit of the byte at position at of what is written
+// through it, in the caller's buffer, so writers sharing the buffer after it,
+// like the copy VerifyAfterWrite keeps, see the corruption too.
+type corruptingWriter struct {
+	at, n int
+}
+
+func (w *corruptingWriter) Write(p []byte) (int, error) {
+	if i := w.at - w.n; i >= 0 && i < len(p) {
+		p[i] ^= 0x10
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func TestVerifyAfterWrite(t *testing.T) {
+	in := sampleText(20000)
+	for _, opts := range []Options{
+		{VerifyAfterWrite: true},
+		{VerifyAfterWrite: true, BlockSize: 999, Digest: true, Passphrase: "secret"},
+	} {
+		roundTrip(t, in, opts)
+		_, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &corruptingWriter{at: 40}, opts)
+		if err != ErrVerifyFailed {
+			t.Errorf("%+v: got %v, want ErrVerifyFailed", opts, err)
+		}
+	}
+}
+
+// TestFormatExamples pins down the examples of FORMAT.md byte for byte:
+// the bit order within bytes, the byte order of fields and the order of
+// the codes of a dictionary. A failure is a change of the format.
+func TestFormatExamples(t *testing.T) {
+	examples := []struct {
+		in, want string
+	}{
+		{"abaa", "425a5a00000000 0002 00000002 6101 6201 01 0000000000000004 0d"},
+		{"", "425a5a00000000 0002 00000000 0000000000000000"},
+		{"aaaa", "425a5a00000000 0002 00000001 6101 00 0000000000000004 00"},
+	}
+	for _, example := range examples {
+		want, err := hex.DecodeString(strings.ReplaceAll(example.want, " ", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var archive bytes.Buffer
+		if err := Compress(strings.NewReader(example.in), &archive); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(archive.Bytes(), want) {
+			t.Errorf("%q compressed to %x, want %x", example.in, archive.Bytes(), want)
+		}
+		var out bytes.Buffer
+		if err := Decompress(bytes.NewReader(want), &out); err != nil || out.String() != example.in {
+			t.Errorf("%x decompressed to %q, %v", want, out.String(), err)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden archives in testdata")
+
+// goldenFixtures are the sources of the golden archives in testdata.
+func goldenFixtures() map[string][]byte {
+	return map[string][]byte{
+		"empty":    nil,
+		"repeated": bytes.Repeat([]byte{'z'}, 1000),
+		"json":     []byte(`{"id": 42, "name": "bee", "tags": ["archiver", "huffman"], "nested": {"ok": true, "ratio": 0.61}}`),
+		"random":   randomBytes(4096),
+		"all":      allBytes(),
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for name, in := range goldenFixtures() {
+		archive := roundTrip(t, in, Options{})
+		// A plain reader takes the in-memory path, not the rewinding one.
+		var buffered bytes.Buffer
+		if err := Compress(bytes.NewBuffer(in), &buffered); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buffered.Bytes(), archive) {
+			t.Errorf("%s: in-memory and rewinding archives differ", name)
+		}
+
+		path := filepath.Join("testdata", name+".bzz")
+		if *update {
+			if err := os.WriteFile(path, archive, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		golden, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(archive, golden) {
+			t.Errorf("%s: archive differs from %s, run with -update if the format changed on purpose", name, path)
+		}
+		var out bytes.Buffer
+		if err := Decompress(bytes.NewReader(golden), &out); err != nil || !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("%s: golden archive does not decompress to the source: %v", name, err)
+		}
+	}
+}
+
+func TestSampleSize(t *testing.T) {
+	// The sample holds text only, the bytes after it every byte value.
+	in := append(sampleText(5000), allBytes()...)
+	for _, opts := range []Options{{SampleSize: 1000}, {SampleSize: 1000, Words: true}, {SampleSize: 100, BlockSize: 2000}} {
+		roundTrip(t, in, opts)
+		var archive, out bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), bytes.NewBuffer(in), &archive, opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := Decompress(&archive, &out); err != nil || !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("%+v: in-memory round trip failed: %v", opts, err)
+		}
+	}
+}
+
+func TestByteOrder(t *testing.T) {
+	in := sampleText(5000)
+	for _, opts := range []Options{{}, {DictionaryVersion: 1}, {BlockSize: 1000}, {Words: true}, {BitCount: true, Name: "name"}} {
+		opts.ByteOrder = binary.BigEndian
+		big := roundTrip(t, in, opts)
+		opts.ByteOrder = binary.LittleEndian
+		little := roundTrip(t, in, opts)
+		if bytes.Equal(big, little) || len(big) != len(little) {
+			t.Errorf("%+v: archives of both byte orders are the same or of different sizes", opts)
+		}
+		if !bytes.Equal(big[:len(magic)], little[:len(magic)]) {
+			t.Errorf("%+v: byte order changed the magic", opts)
+		}
+	}
+	if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), io.Discard, Options{ByteOrder: binary.NativeEndian}); err != ErrUnsupportedByteOrder {
+		t.Fatalf("native byte order: got %v, want ErrUnsupportedByteOrder", err)
+	}
+}
+
+func TestStatsSizes(t *testing.T) {
+	in := sampleText(10000)
+	for _, opts := range []Options{{}, {BlockSize: 3000}, {Digest: true, Name: "name"}, {Words: true}} {
+		var archive bytes.Buffer
+		stats, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &archive, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.OriginalSize != uint64(len(in)) || stats.CompressedSize != uint64(archive.Len()) {
+			t.Errorf("%+v: compression stats %d and %d bytes, want %d and %d",
+				opts, stats.OriginalSize, stats.CompressedSize, len(in), archive.Len())
+		}
+		// Decompression does not know the archive size.
+		stats, err = DecompressWithStats(&archive, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.OriginalSize != uint64(len(in)) || stats.CRC32 != crc32.ChecksumIEEE(in) {
+			t.Errorf("%+v: decompression stats %d bytes of CRC-32 %08x, want %d of %08x",
+				opts, stats.OriginalSize, stats.CRC32, len(in), crc32.ChecksumIEEE(in))
+		}
+	}
+}
+
+func TestMaxOutputSize(t *testing.T) {
+	var archive bytes.Buffer
+	if err := Compress(strings.NewReader("abaa"), &archive); err != nil {
+		t.Fatal(err)
+	}
+	// Claim an enormous size and cut the payload off, so only the
+	// size check can tell the archive apart from a truncated one.
+	bomb := bytes.Clone(archive.Bytes()[:archive.Len()-1])
+	binary.BigEndian.PutUint64(bomb[len(bomb)-8:], 1<<62)
+
+	var out bytes.Buffer
+	opts := Options{MaxOutputSize: 1 << 20}
+	_, err := DecompressWithOptions(context.Background(), bytes.NewReader(bomb), &out, opts)
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Fatalf("got %v, want ErrOutputTooLarge", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("%d bytes written before the size was refused", out.Len())
+	}
+
+	// An archive within the limit decompresses as usual.
+	opts.MaxOutputSize = 4
+	if _, err = DecompressWithOptions(context.Background(), bytes.NewReader(archive.Bytes()), &out, opts); err != nil || out.String() != "abaa" {
+		t.Fatalf("decompressed to %q, %v", out.String(), err)
+	}
+	out.Reset()
+	opts.MaxOutputSize = 3
+	if _, err = DecompressWithOptions(context.Background(), bytes.NewReader(archive.Bytes()), &out, opts); !errors.Is(err, ErrOutputTooLarge) {
+		t.Fatalf("4 bytes with a limit of 3: got %v, want ErrOutputTooLarge", err)
+	}
+}
+
+func TestBitCount(t *testing.T) {
+	for _, in := range [][]byte{[]byte("a"), []byte("abaa"), sampleText(999), randomBytes(1000)} {
+		archive := roundTrip(t, in, Options{BitCount: true})
+
+		// Decode up to the bit the count marks the end of, ignoring the size.
+		reader := NewReader(bytes.NewReader(archive[headerSize:]))
+		tree, err := readDictionary(reader, false, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = readFileSize(reader, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		valid := archive[len(archive)-1]
+		end := 8*uint64(len(archive)-headerSize-2) + uint64(valid)
+		var out []byte
+		for reader.BitsRead() < end {
+			leaf, err := decodeSymbol(tree, reader)
+			if err != nil {
+				t.Fatalf("%d bytes: %v", len(in), err)
+			}
+			out = append(out, byte(leaf.Value))
+		}
+		if !bytes.Equal(out, in) {
+			t.Errorf("%d bytes: decoded %d bytes by the bit count", len(in), len(out))
+		}
+	}
+}
+
+func TestDecompressInto(t *testing.T) {
+	in := sampleText(5000)
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &archive); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ReadInfo(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []uint64{info.OriginalSize, info.OriginalSize + 100} {
+		dst := make([]byte, size)
+		n, err := DecompressInto(archive.Bytes(), dst)
+		if err != nil {
+			t.Fatalf("%d byte buffer: %v", size, err)
+		}
+		if n != len(in) || !bytes.Equal(dst[:n], in) {
+			t.Errorf("%d byte buffer: decompressed %d bytes, want %d", size, n, len(in))
+		}
+	}
+
+	if _, err = DecompressInto(archive.Bytes(), make([]byte, len(in)-1)); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("a byte short: got %v, want ErrShortBuffer", err)
+	}
+}
+
+// TestConcurrent compresses and decompresses independent sources from many
+// goroutines sharing one Options, run it with -race. Half of them go
+// through pooled readers and writers, which are shared by the goroutines.
+func TestConcurrent(t *testing.T) {
+	opts := Options{Workers: 2, BufferSize: 4096}
+	text := sampleText(64 << 10)
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			in := text[g*1000 : g*1000+(g+1)*3000]
+			pooled := g%2 == 1
+
+			var archive bytes.Buffer
+			var dst io.Writer = &archive
+			if pooled {
+				dst = GetWriter(&archive)
+			}
+			if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), dst, opts); err != nil {
+				t.Errorf("goroutine %d: compress: %v", g, err)
+				return
+			}
+			if pooled {
+				w := dst.(Writer)
+				if err := w.Close(); err != nil {
+					t.Errorf("goroutine %d: %v", g, err)
+					return
+				}
+				PutWriter(w)
+			}
+
+			var src io.Reader = bytes.NewReader(archive.Bytes())
+			if pooled {
+				r := GetReader(src)
+				defer PutReader(r)
+				src = r
+			}
+			var out bytes.Buffer
+			if _, err := DecompressWithOptions(context.Background(), src, &out, opts); err != nil {
+				t.Errorf("goroutine %d: decompress: %v", g, err)
+				return
+			}
+			if !bytes.Equal(out.Bytes(), in) {
+				t.Errorf("goroutine %d: round trip changed the data", g)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// seekerOnly hides all but the io.ReadSeeker methods of a source.
+type seekerOnly struct {
+	io.ReadSeeker
+}
+
+func TestCompressSeeker(t *testing.T) {
+	in := sampleText(100000)
+	// Read in one go, not rewound, for the archive to compare against.
+	var want bytes.Buffer
+	if _, err := CompressWithOptions(context.Background(), io.MultiReader(bytes.NewReader(in)), &want, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	sources := map[string]func() io.Reader{
+		"bytes.Reader": func() io.Reader { return bytes.NewReader(in) },
+		"seeker only":  func() io.Reader { return seekerOnly{bytes.NewReader(in)} },
+	}
+	for name, source := range sources {
+		var archive bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), source(), &archive, Options{Workers: 4}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(archive.Bytes(), want.Bytes()) {
+			t.Errorf("%s: rewinding the source gave another archive", name)
+		}
+	}
+
+	// Compression starts at the current offset of the source.
+	src := bytes.NewReader(in)
+	if _, err := src.Seek(1000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if _, err := CompressWithOptions(context.Background(), src, &archive, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Decompress(bytes.NewReader(archive.Bytes()), &out); err != nil || !bytes.Equal(out.Bytes(), in[1000:]) {
+		t.Fatalf("from offset 1000: decompressed %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestNonRegularSource(t *testing.T) {
+	in := sampleText(100000)
+	var want bytes.Buffer
+	if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &want, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pipe cannot be rewound, so it is read as a stream.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write(in)
+		w.Close()
+	}()
+	if _, ok, err := sourceSeeker(r); ok || err != nil {
+		t.Fatalf("pipe: seekable %v, %v", ok, err)
+	}
+	var archive bytes.Buffer
+	stats, err := CompressWithOptions(context.Background(), r, &archive, Options{})
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	if stats.OriginalSize != uint64(len(in)) || !bytes.Equal(archive.Bytes(), want.Bytes()) {
+		t.Errorf("pipe: archive of %d bytes differs from the one of the bytes", stats.OriginalSize)
+	}
+
+	dir, err := os.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	archive.Reset()
+	if _, err := CompressWithOptions(context.Background(), dir, &archive, Options{}); err != ErrNotRegularFile {
+		t.Errorf("directory: got %v, want ErrNotRegularFile", err)
+	}
+	if archive.Len() != 0 {
+		t.Errorf("directory: %d bytes written", archive.Len())
+	}
+}
+
+func TestExpanded(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       []byte
+		expanded bool
+	}{
+		{"50 bytes", sampleText(50), true},
+		{"text", sampleText(10000), false},
+	}
+	for _, tt := range tests {
+		var archive bytes.Buffer
+		stats, err := CompressWithOptions(context.Background(), bytes.NewReader(tt.in), &archive, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Expanded != tt.expanded {
+			t.Errorf("%s: expanded %v, want %v, %d bytes to %d", tt.name, stats.Expanded, tt.expanded, len(tt.in), archive.Len())
+		}
+		estimate, err := EstimateSize(bytes.NewReader(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (estimate > uint64(len(tt.in))) != tt.expanded {
+			t.Errorf("%s: estimated %d bytes for %d", tt.name, estimate, len(tt.in))
+		}
+	}
+}
+
+func TestDecodeErrorOffset(t *testing.T) {
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(sampleText(10000)), &archive); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ReadInfo(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The payload follows the dictionary and the size of the source.
+	payload := archive.Len() - 1
+	for payload > headerSize && binary.BigEndian.Uint64(archive.Bytes()[payload-8:]) != info.OriginalSize {
+		payload--
+	}
+
+	unsupported := bytes.Clone(archive.Bytes())
+	binary.BigEndian.PutUint16(unsupported[headerSize:], 9)
+	tests := []struct {
+		name   string
+		data   []byte
+		phase  string
+		offset uint64
+		err    error
+	}{
+		// The version is only checked once the count following it is read.
+		{"dictionary version", unsupported, PhaseDictionary, 48, ErrUnsupportedVersion},
+		// The bytes of the size read are counted, up to where the archive ends.
+		{"size cut short", archive.Bytes()[:payload-3], PhaseHeader, 8 * uint64(payload-3-headerSize), io.ErrUnexpectedEOF},
+		{"payload cut short", archive.Bytes()[:payload+100], PhasePayload, 8 * uint64(payload+100-headerSize), io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		err := Decompress(bytes.NewReader(tt.data), io.Discard)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("%s: got %v, want a *DecodeError", tt.name, err)
+			continue
+		}
+		if decodeErr.Phase != tt.phase || decodeErr.Offset != tt.offset || !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %s at bit %d: %v", tt.name, err, tt.phase, tt.offset, tt.err)
+		}
+	}
+}
+
+func TestOneByteReads(t *testing.T) {
+	in := sampleText(30000)
+	var want bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	freqs, err := Scan(iotest.OneByteReader(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFreqs, err := Scan(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freqs != wantFreqs {
+		t.Error("reads of a byte each counted other frequencies")
+	}
+
+	for _, opts := range []Options{{}, {BlockSize: 4096}, {RLE: true}} {
+		var archive bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), iotest.OneByteReader(bytes.NewReader(in)), &archive, opts); err != nil {
+			t.Fatal(err)
+		}
+		if opts.BlockSize == 0 && !opts.RLE && !bytes.Equal(archive.Bytes(), want.Bytes()) {
+			t.Error("reads of a byte each gave another archive")
+		}
+		var out bytes.Buffer
+		if _, err := DecompressWithOptions(context.Background(), iotest.OneByteReader(bytes.NewReader(archive.Bytes())), &out, opts); err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("%+v: round trip of reads of a byte each changed the data", opts)
+		}
+	}
+}
+
+func TestWillFitInBudget(t *testing.T) {
+	in := sampleText(10000)
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &archive); err != nil {
+		t.Fatal(err)
+	}
+	size := uint64(archive.Len())
+
+	for _, budget := range []uint64{size - 1, size, size + 1, 0} {
+		fits, estimate, err := WillFitInBudget(bytes.NewReader(in), budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate != size {
+			t.Errorf("budget %d: estimated %d bytes, Compress wrote %d", budget, estimate, size)
+		}
+		if fits != (budget >= size) {
+			t.Errorf("budget %d: fits %v for %d bytes", budget, fits, size)
+		}
+	}
+}
+
+func TestExpectedCRC32(t *testing.T) {
+	in := sampleText(5000)
+	for _, opts := range []Options{{}, {BlockSize: 1000}, {RLE: true}, {Store: true}} {
+		var archive bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &archive, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		correct := crc32.ChecksumIEEE(in)
+		opts.CRC32 = &correct
+		if _, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive.Bytes()), io.Discard, opts); err != nil {
+			t.Errorf("%+v: correct CRC: %v", opts, err)
+		}
+		incorrect := correct ^ 1
+		opts.CRC32 = &incorrect
+		if _, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive.Bytes()), io.Discard, opts); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("%+v: incorrect CRC: got %v, want ErrChecksumMismatch", opts, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	in := sampleText(5000)
+	for _, opts := range []Options{{}, {Digest: true}, {BlockSize: 1000}, {SizeFooter: true}} {
+		archive := roundTrip(t, in, opts)
+		if err := Validate(bytes.NewReader(archive)); err != nil {
+			t.Errorf("%+v: valid archive: %v", opts, err)
+		}
+		if err := Validate(bytes.NewReader(archive[:len(archive)-40])); err == nil {
+			t.Errorf("%+v: truncated archive passed", opts)
+		}
+	}
+
+	// The last byte is of the digest, which decoding does not need.
+	archive := roundTrip(t, in, Options{Digest: true})
+	archive[len(archive)-1] ^= 1
+	if err := Validate(bytes.NewReader(archive)); err != ErrDigestMismatch {
+		t.Errorf("altered digest: got %v, want ErrDigestMismatch", err)
+	}
+}
+
+func TestZeroPadding(t *testing.T) {
+	padded := 0
+	for n := 1; n <= 64; n++ {
+		in := sampleText(n)
+		var archive bytes.Buffer
+		if err := Compress(bytes.NewReader(in), &archive); err != nil {
+			t.Fatal(err)
+		}
+		info, err := ReadInfo(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lengths := make(map[uint16]int)
+		for _, code := range info.Codes {
+			lengths[code.Symbol] = code.Len()
+		}
+		bits := 0
+		for _, value := range in {
+			bits += lengths[uint16(value)]
+		}
+
+		// The unused high bits of the last byte are all zero.
+		if used := bits % 8; used > 0 {
+			padded++
+			if last := archive.Bytes()[archive.Len()-1]; last>>used != 0 {
+				t.Errorf("%d bytes: last byte %08b has %d padding bits set", n, last, 8-used)
+			}
+		}
+	}
+	if padded == 0 {
+		t.Fatal("no payload ended within a byte")
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	in := sampleText(3000)
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &archive); err != nil {
+		t.Fatal(err)
+	}
+	// Archives written before the header are the rest of a plain archive.
+	legacy := archive.Bytes()[headerSize:]
+
+	tests := []struct {
+		name   string
+		data   []byte
+		legacy bool
+		ok     bool
+	}{
+		{"legacy archive, legacy option", legacy, true, true},
+		{"legacy archive", legacy, false, true},
+		{"archive", archive.Bytes(), false, true},
+		{"archive, legacy option", archive.Bytes(), true, false},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		_, err := DecompressWithOptions(context.Background(), bytes.NewReader(tt.data), &out, Options{Legacy: tt.legacy})
+		if ok := err == nil && bytes.Equal(out.Bytes(), in); ok != tt.ok {
+			t.Errorf("%s: decompressed %d bytes, %v", tt.name, out.Len(), err)
+		}
+	}
+
+	info, err := ReadInfo(bytes.NewReader(legacy), int64(len(legacy)))
+	if err != nil || !info.Legacy || info.OriginalSize != uint64(len(in)) {
+		t.Errorf("legacy archive: info %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,40 @@
+// Options of the bit Reader and Writer.
+package main
+
+// BitOption configures a Reader or a Writer, see NewReader and NewWriter.
+type BitOption func(*bitConfig)
+
+// bitConfig is what BitOptions set.
+type bitConfig struct {
+	bufferSize  int  // size of the bufio wrapper, 0 for the bufio default
+	strictAlign bool // Align fails if bits are pending
+}
+
+// newBitConfig applies opts to the default configuration.
+func newBitConfig(opts []BitOption) bitConfig {
+	var config bitConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// WithBufferSize sets the size of the bufio.Reader or bufio.Writer the source
+// or the output is wrapped in, when it is not buffered already.
+// Zero or less means the bufio default.
+func WithBufferSize(size int) BitOption {
+	return func(c *bitConfig) {
+		c.bufferSize = size
+	}
+}
+
+// WithStrictAlign makes Align fail with ErrUnexpectedAlign when bits are pending,
+// for streams which are meant to reach byte boundaries on their own.
+// A Writer then leaves its cached bits in place instead of padding them,
+// so Close fails too. A Reader likewise keeps its unread bits, and reading
+// goes on from them.
+func WithStrictAlign() BitOption {
+	return func(c *bitConfig) {
+		c.strictAlign = true
+	}
+}
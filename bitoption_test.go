@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// countingReader counts the bytes read from an io.Reader.
+type countingReader struct {
+	in io.Reader
+	n  int
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.in.Read(p)
+	r.n += n
+	return
+}
+
+func TestWithBufferSize(t *testing.T) {
+	for _, size := range []int{0, 64} {
+		// writerOnly is no io.ByteWriter, so it gets wrapped.
+		var out bytes.Buffer
+		w := NewWriter(writerOnly{&out}, WithBufferSize(size))
+		for i := 0; i < 100; i++ {
+			w.WriteByte(byte(i))
+		}
+		want := 0
+		if size > 0 {
+			want = size
+		}
+		if out.Len() != want {
+			t.Errorf("buffer size %d: %d of 100 bytes passed on, want %d", size, out.Len(), want)
+		}
+
+		src := &countingReader{in: bytes.NewReader(make([]byte, 10000))}
+		r := NewReader(src, WithBufferSize(size))
+		if _, err := r.ReadByte(); err != nil {
+			t.Fatal(err)
+		}
+		want = 4096 // the bufio default
+		if size > 0 {
+			want = size
+		}
+		if src.n != want {
+			t.Errorf("buffer size %d: read %d bytes for one, want %d", size, src.n, want)
+		}
+	}
+}
+
+func TestWithStrictAlign(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, WithStrictAlign())
+	w.WriteByte(0xa5)
+	if _, err := w.Align(); err != nil {
+		t.Fatalf("aligned writer: %v", err)
+	}
+	w.WriteBool(true)
+	if _, err := w.Align(); err != ErrUnexpectedAlign {
+		t.Fatalf("pending bits: got %v, want ErrUnexpectedAlign", err)
+	}
+	if err := w.Close(); err != ErrUnexpectedAlign {
+		t.Fatalf("closing with pending bits: got %v, want ErrUnexpectedAlign", err)
+	}
+	// The bits are kept, so the writer can still reach a byte boundary.
+	for i := 0; i < 7; i++ {
+		w.WriteBool(false)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), []byte{0xa5, 0x01}) {
+		t.Fatalf("wrote %x, want a501", out.Bytes())
+	}
+
+	r := NewReader(bytes.NewReader([]byte{0xa5, 0x01, 0x02}), WithStrictAlign())
+	r.ReadByte()
+	if skipped, err := r.Align(); err != nil || skipped != 0 {
+		t.Fatalf("aligned reader skipped %d bits, %v", skipped, err)
+	}
+	r.ReadBool()
+	if _, err := r.Align(); err != ErrUnexpectedAlign {
+		t.Fatalf("misaligned reader: got %v, want ErrUnexpectedAlign", err)
+	}
+	if n := r.BitsRead(); n != 9 {
+		t.Fatalf("failed Align moved the reader to bit %d, want 9", n)
+	}
+	if _, err := r.ReadByte(); err != nil {
+		t.Fatalf("read after misaligning: %v", err)
+	}
+}
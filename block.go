@@ -0,0 +1,331 @@
+// Block mode: the source split into independently compressed blocks.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Block describes one block of a block mode archive.
+type Block struct {
+	Offset uint64 // position of the compressed block in the archive
+	Size   uint64 // number of uncompressed bytes
+}
+
+// writeBlocks writes data split into blocks of opts.BlockSize bytes,
+// or at content-defined boundaries with opts.Chunking, each compressed
+// as a single stream with its own dictionary, preceded by the block index.
+// Blocks are compressed by opts.Workers goroutines, each into a buffer
+// of its own, and written in order, so the archive does not depend on
+// which of them finishes first.
+func writeBlocks(ctx context.Context, data []byte, dst io.Writer, flags uint32, opts Options) (Stats, error) {
+	ends := blockEnds(data, opts)
+	count := len(ends)
+	blocks := make([]bytes.Buffer, count)
+	index := make([]Block, count)
+	results := make([]Stats, count)
+	errs := make([]error, count)
+
+	// Blocks are compressed in parallel already, not their scans.
+	blockOpts := opts
+	blockOpts.Progress = nil
+	blockOpts.Workers = 1
+
+	next := make(chan int)
+	var mu sync.Mutex
+	var done uint64
+
+	var wg sync.WaitGroup
+	for w := 0; w < opts.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				start := 0
+				if i > 0 {
+					start = ends[i-1]
+				}
+				end := ends[i]
+				results[i], errs[i] = encode(ctx, data[start:end], &blocks[i], flags, blockOpts)
+
+				mu.Lock()
+				done += uint64(end - start)
+				opts.progress(done, uint64(len(data)))
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range blocks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var stats Stats
+	for i, block := range results {
+		if errs[i] != nil {
+			return Stats{}, errs[i]
+		}
+		index[i].Size = block.OriginalSize
+		stats.OriginalSize += block.OriginalSize
+		stats.DictionarySize += block.DictionarySize
+	}
+
+	var symbols [256]bool
+	for _, value := range data {
+		symbols[value] = true
+	}
+	for _, present := range symbols {
+		if present {
+			stats.Symbols++
+		}
+	}
+
+	offset := indexStart(opts.Name) + 4 + 16*uint64(count)
+	for i := range index {
+		index[i].Offset = offset
+		offset += uint64(blocks[i].Len())
+	}
+
+	if err := binary.Write(dst, byteOrder(flags), uint32(count)); err != nil {
+		return Stats{}, err
+	}
+	if err := binary.Write(dst, byteOrder(flags), index); err != nil {
+		return Stats{}, err
+	}
+	for i := range blocks {
+		if _, err := dst.Write(blocks[i].Bytes()); err != nil {
+			return Stats{}, err
+		}
+	}
+	return stats, nil
+}
+
+// blockEnds returns where every block of data ends.
+func blockEnds(data []byte, opts Options) []int {
+	if opts.Chunking {
+		return chunkEnds(data, opts.BlockSize)
+	}
+	var ends []int
+	for end := opts.BlockSize; end < len(data); end += opts.BlockSize {
+		ends = append(ends, end)
+	}
+	if len(data) > 0 {
+		ends = append(ends, len(data))
+	}
+	return ends
+}
+
+// indexStart returns the position of the block index in an archive
+// storing name, right after the header and the name.
+func indexStart(name string) uint64 {
+	return uint64(headerSize + nameSize(name))
+}
+
+// readBlockIndex reads the block index following the header, which starts
+// at start in the archive. The offsets come from the archive, so they must
+// point past the end of the index and fit an int64 for seeking.
+func readBlockIndex(reader io.Reader, order binary.ByteOrder, start uint64) ([]Block, error) {
+	var count uint32
+	if err := binary.Read(reader, order, &count); err != nil {
+		return nil, err
+	}
+	end := start + 4 + 16*uint64(count)
+	var blocks []Block
+	for i := uint32(0); i < count; i++ {
+		var block Block
+		if err := binary.Read(reader, order, &block); err != nil {
+			return nil, err
+		}
+		if block.Offset < end || block.Offset > math.MaxInt64 {
+			return nil, ErrCorruptBlock
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// readBlocks decodes all the blocks of a block mode archive storing name
+// in order and returns the number of bytes written to dst.
+func readBlocks(ctx context.Context, reader Reader, dst io.Writer, flags uint32, name string, opts Options) (uint64, error) {
+	blocks, err := readBlockIndex(reader, byteOrder(flags), indexStart(name))
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, block := range blocks {
+		total += block.Size
+	}
+
+	var written uint64
+	for _, block := range blocks {
+		base := written
+		blockOpts := opts
+		blockOpts.Progress = func(processed, _ uint64) {
+			opts.progress(base+processed, total)
+		}
+		size, err := decode(ctx, reader, dst, flags, blockOpts)
+		if err != nil {
+			return written, err
+		}
+		if size != block.Size {
+			return written, ErrCorruptBlock
+		}
+		written += size
+	}
+	return written, nil
+}
+
+// ReadBlocks returns the block index of a block mode archive.
+func ReadBlocks(archive io.Reader) ([]Block, error) {
+	_, blocks, err := readIndex(archive)
+	return blocks, err
+}
+
+// readIndex reads the header and the block index of a block mode archive.
+func readIndex(archive io.Reader) (uint32, []Block, error) {
+	in := bufio.NewReader(archive)
+	flags, err := readHeader(in)
+	if err != nil {
+		return 0, nil, err
+	}
+	if flags&flagBlocks == 0 {
+		return 0, nil, ErrNotBlockArchive
+	}
+	if flags&flagEncrypted != 0 {
+		return 0, nil, ErrEncrypted
+	}
+	name, err := readName(in, flags)
+	if err != nil {
+		return 0, nil, err
+	}
+	blocks, err := readBlockIndex(in, byteOrder(flags), indexStart(name))
+	return flags, blocks, err
+}
+
+// DecompressBlock decompresses the i-th block of a block mode archive
+// without decoding the blocks before it.
+func DecompressBlock(archive io.ReaderAt, i int, dst io.Writer) error {
+	flags, blocks, err := readIndex(io.NewSectionReader(archive, 0, math.MaxInt64))
+	if err != nil {
+		return err
+	}
+	if i < 0 || i >= len(blocks) {
+		return ErrBlockOutOfRange
+	}
+	return decodeBlock(archive, blocks[i], dst, flags)
+}
+
+// DecompressRange writes length bytes of the original data starting at offset,
+// decoding only the blocks covering that range.
+func DecompressRange(archive io.ReaderAt, offset, length uint64, dst io.Writer) error {
+	flags, blocks, err := readIndex(io.NewSectionReader(archive, 0, math.MaxInt64))
+	if err != nil {
+		return err
+	}
+
+	var start uint64
+	for _, block := range blocks {
+		end := start + block.Size
+		if length > 0 && offset < end && offset+length > start {
+			buf := new(bytes.Buffer)
+			if err = decodeBlock(archive, block, buf, flags); err != nil {
+				return err
+			}
+			data := buf.Bytes()
+			if offset > start {
+				data = data[offset-start:]
+			}
+			if offset+length < end {
+				data = data[:uint64(len(data))-(end-offset-length)]
+			}
+			if _, err = dst.Write(data); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	if offset+length > start {
+		return ErrBlockOutOfRange
+	}
+	return nil
+}
+
+// decodeBlock decodes a single block located by the index.
+func decodeBlock(archive io.ReaderAt, block Block, dst io.Writer, flags uint32) error {
+	reader := NewReader(io.NewSectionReader(archive, int64(block.Offset), math.MaxInt64-int64(block.Offset)))
+	size, err := decode(context.Background(), reader, dst, flags, Options{})
+	if err != nil {
+		return err
+	}
+	if size != block.Size {
+		return ErrCorruptBlock
+	}
+	return nil
+}
+
+// DecompressBlocksAt decompresses all the blocks of a block mode archive
+// straight to their places in dst, opts.Workers blocks at a time.
+// Only OriginalSize and Elapsed of the returned stats are filled in.
+func DecompressBlocksAt(archive io.ReaderAt, dst io.WriterAt, opts Options) (Stats, error) {
+	start := time.Now()
+
+	if err := opts.validate(); err != nil {
+		return Stats{}, err
+	}
+
+	flags, blocks, err := readIndex(io.NewSectionReader(archive, 0, math.MaxInt64))
+	if err != nil {
+		return Stats{}, err
+	}
+
+	offsets := make([]int64, len(blocks))
+	var total uint64
+	for i, block := range blocks {
+		offsets[i] = int64(total)
+		total += block.Size
+	}
+
+	next := make(chan int)
+	errs := make([]error, len(blocks))
+	var mu sync.Mutex
+	var done uint64
+
+	var wg sync.WaitGroup
+	for w := 0; w < opts.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = decodeBlock(archive, blocks[i], io.NewOffsetWriter(dst, offsets[i]), flags)
+
+				mu.Lock()
+				done += blocks[i].Size
+				opts.progress(done, total)
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range blocks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return Stats{}, err
+		}
+	}
+	return Stats{
+		OriginalSize: total,
+		Elapsed:      time.Since(start),
+	}, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestDecompressBlock(t *testing.T) {
+	in := sampleText(50000)
+	archive := roundTrip(t, in, Options{BlockSize: 10000})
+	blocks, err := ReadBlocks(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 5 {
+		t.Fatalf("%d blocks, want 5", len(blocks))
+	}
+	var out bytes.Buffer
+	if err := DecompressBlock(bytes.NewReader(archive), 3, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), in[30000:40000]) {
+		t.Fatal("block 3 differs from the source")
+	}
+	for _, i := range []int{-1, 5} {
+		if err := DecompressBlock(bytes.NewReader(archive), i, &out); err != ErrBlockOutOfRange {
+			t.Errorf("block %d: got %v, want ErrBlockOutOfRange", i, err)
+		}
+	}
+}
+
+func TestDecompressRange(t *testing.T) {
+	in := sampleText(50000)
+	archive := roundTrip(t, in, Options{BlockSize: 10000})
+	ranges := [][2]uint64{{0, 1}, {9995, 10}, {5, 30000}, {49993, 7}, {0, 50000}, {123, 0}}
+	for _, r := range ranges {
+		var out bytes.Buffer
+		if err := DecompressRange(bytes.NewReader(archive), r[0], r[1], &out); err != nil {
+			t.Fatalf("range %v: %v", r, err)
+		}
+		if !bytes.Equal(out.Bytes(), in[r[0]:r[0]+r[1]]) {
+			t.Errorf("range %v differs from the source", r)
+		}
+	}
+	if err := DecompressRange(bytes.NewReader(archive), 49990, 11, new(bytes.Buffer)); err != ErrBlockOutOfRange {
+		t.Fatalf("range past the end: got %v, want ErrBlockOutOfRange", err)
+	}
+}
+
+func TestBlockIndexOffsets(t *testing.T) {
+	archive := roundTrip(t, sampleText(100), Options{BlockSize: 50})
+	// The first offset follows the header and the count.
+	at := headerSize + 4
+	for _, offset := range []uint64{0, uint64(headerSize), 1 << 63, 1<<64 - 1} {
+		corrupt := append([]byte(nil), archive...)
+		binary.BigEndian.PutUint64(corrupt[at:], offset)
+		if _, err := ReadBlocks(bytes.NewReader(corrupt)); err != ErrCorruptBlock {
+			t.Errorf("offset %#x: ReadBlocks got %v, want ErrCorruptBlock", offset, err)
+		}
+		if err := DecompressBlock(bytes.NewReader(corrupt), 0, new(bytes.Buffer)); err != ErrCorruptBlock {
+			t.Errorf("offset %#x: DecompressBlock got %v, want ErrCorruptBlock", offset, err)
+		}
+	}
+
+	// Found by fuzzing: an offset beyond int64 made the section reader panic.
+	fuzzed := []byte("BZZ\x00\x0001\x00\x00\x00\x01\xff000000000000000")
+	if err := DecompressBlock(bytes.NewReader(fuzzed), 0, new(bytes.Buffer)); err == nil {
+		t.Fatal("fuzzed archive decompressed")
+	}
+
+	// With a stored name, the index follows it, so the end of the index
+	// of an unnamed archive is inside it.
+	named := roundTrip(t, sampleText(100), Options{BlockSize: 50, Name: "name"})
+	binary.BigEndian.PutUint64(named[indexStart("name")+4:], uint64(headerSize+4+16*2))
+	if _, err := ReadBlocks(bytes.NewReader(named)); err != ErrCorruptBlock {
+		t.Errorf("offset inside the index after a name: got %v, want ErrCorruptBlock", err)
+	}
+}
+
+// bufferAt is an io.WriterAt of a fixed size buffer, safe for concurrent use.
+type bufferAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *bufferAt) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if off+int64(len(p)) > int64(len(b.buf)) {
+		return 0, io.ErrShortWrite
+	}
+	return copy(b.buf[off:], p), nil
+}
+
+func TestDecompressBlocksAt(t *testing.T) {
+	in := sampleText(100000)
+	archive := roundTrip(t, in, Options{BlockSize: 7000})
+	for _, workers := range []int{1, 8} {
+		out := &bufferAt{buf: make([]byte, len(in))}
+		stats, err := DecompressBlocksAt(bytes.NewReader(archive), out, Options{Workers: workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.OriginalSize != uint64(len(in)) || !bytes.Equal(out.buf, in) {
+			t.Errorf("%d workers: output differs from the source", workers)
+		}
+	}
+}
+
+func TestBlockWorkers(t *testing.T) {
+	in := append(sampleText(1<<20), randomBytes(100000)...)
+	for _, opts := range []Options{{BlockSize: 32 << 10}, {BlockSize: 32 << 10, Chunking: true}} {
+		archives := make(map[int][]byte)
+		for _, workers := range []int{1, 8} {
+			opts.Workers = workers
+			archives[workers] = roundTrip(t, in, opts)
+		}
+		if !bytes.Equal(archives[1], archives[8]) {
+			t.Errorf("chunking %v: 8 workers wrote another archive than 1", opts.Chunking)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Content-defined chunking: block boundaries chosen by a rolling hash.
+package main
+
+import "math/bits"
+
+// gear holds a fixed pseudo-random value for every byte value, mixed into
+// the rolling hash. It must never change, or the same data would be
+// chunked differently by different versions.
+var gear = func() (table [256]uint64) {
+	// splitmix64
+	var state uint64
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = z ^ z>>31
+	}
+	return table
+}()
+
+// chunkEnds returns where every chunk of data ends, cutting where the top
+// bits of a gear hash of the last 64 bytes are all zero, so chunks are around
+// size bytes long. Chunks are at least a quarter of size and at
+// most four times size long, the last one aside.
+// The hash runs over data as a whole, so the boundaries only depend on the
+// bytes around them, and an edit leaves the boundaries away from it in place.
+func chunkEnds(data []byte, size int) []int {
+	minSize, maxSize := size/4, size*4
+	// Cuts are only looked for past minSize, so the hash has to match
+	// about once every size-minSize bytes.
+	mask := ^uint64(0) << (64 - (bits.Len(uint(size-minSize)) - 1))
+
+	var ends []int
+	var hash uint64
+	start := 0
+	for i, value := range data {
+		hash = hash<<1 + gear[value]
+		length := i + 1 - start
+		if (length >= minSize && hash&mask == 0) || length >= maxSize {
+			ends = append(ends, i+1)
+			start = i + 1
+		}
+	}
+	if start < len(data) {
+		ends = append(ends, len(data))
+	}
+	return ends
+}
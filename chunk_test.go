@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// compressedBlocks returns the compressed bytes of every block of archive.
+func compressedBlocks(t *testing.T, archive []byte) [][]byte {
+	t.Helper()
+	blocks, err := ReadBlocks(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := make([][]byte, len(blocks))
+	for i, block := range blocks {
+		end := uint64(len(archive))
+		if i+1 < len(blocks) {
+			end = blocks[i+1].Offset
+		}
+		compressed[i] = archive[block.Offset:end]
+	}
+	return compressed
+}
+
+func TestChunkEnds(t *testing.T) {
+	in := sampleText(200000)
+	ends := chunkEnds(in, 4096)
+	if len(ends) < 2 || ends[len(ends)-1] != len(in) {
+		t.Fatalf("ends %v do not cover %d bytes", ends, len(in))
+	}
+	start := 0
+	for i, end := range ends {
+		length := end - start
+		if length > 4*4096 || (length < 4096/4 && i < len(ends)-1) {
+			t.Errorf("chunk %d is %d bytes long", i, length)
+		}
+		start = end
+	}
+
+	// A byte inserted into the first chunk moves the later boundaries with it.
+	edited := append(append(append([]byte(nil), in[:100]...), 'x'), in[100:]...)
+	moved := chunkEnds(edited, 4096)
+	if len(moved) != len(ends) {
+		t.Fatalf("%d chunks after the insertion, want %d", len(moved), len(ends))
+	}
+	for i := range ends {
+		if moved[i] != ends[i]+1 {
+			t.Errorf("chunk %d ends at %d, want %d", i, moved[i], ends[i]+1)
+		}
+	}
+}
+
+func TestChunkingInsertion(t *testing.T) {
+	in := sampleText(200000)
+	edited := append(append(append([]byte(nil), in[:100]...), 'x'), in[100:]...)
+
+	opts := Options{BlockSize: 4096, Chunking: true}
+	before := compressedBlocks(t, roundTrip(t, in, opts))
+	after := compressedBlocks(t, roundTrip(t, edited, opts))
+	if len(after) != len(before) {
+		t.Fatalf("%d chunks after the insertion, want %d", len(after), len(before))
+	}
+	if bytes.Equal(after[0], before[0]) {
+		t.Error("first chunk unchanged by the insertion")
+	}
+	for i := 1; i < len(before); i++ {
+		if !bytes.Equal(after[i], before[i]) {
+			t.Errorf("chunk %d changed by an insertion into chunk 0", i)
+		}
+	}
+
+	// Fixed blocks all shift instead.
+	opts.Chunking = false
+	before = compressedBlocks(t, roundTrip(t, in, opts))
+	after = compressedBlocks(t, roundTrip(t, edited, opts))
+	if bytes.Equal(after[len(after)-2], before[len(before)-2]) {
+		t.Error("fixed block unchanged by an insertion before it")
+	}
+}
+
+func TestChunkingWithoutBlockSize(t *testing.T) {
+	if err := (Options{Chunking: true}).validate(); err != ErrChunkingMode {
+		t.Fatalf("got %v, want ErrChunkingMode", err)
+	}
+}
@@ -0,0 +1,37 @@
+// CRC-32 of data passing through, so it takes no pass of its own.
+package main
+
+import (
+	"hash/crc32"
+	"io"
+)
+
+// crcReader passes on what it reads from the underlying io.Reader,
+// keeping the IEEE CRC-32 and the number of bytes of it.
+type crcReader struct {
+	in  io.Reader
+	crc uint32
+	n   uint64
+}
+
+// Read implements io.Reader.
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.in.Read(p)
+	r.crc = crc32.Update(r.crc, crc32.IEEETable, p[:n])
+	r.n += uint64(n)
+	return n, err
+}
+
+// crcWriter passes what is written to it to the underlying io.Writer,
+// keeping the IEEE CRC-32 of what the io.Writer took.
+type crcWriter struct {
+	out io.Writer
+	crc uint32
+}
+
+// Write implements io.Writer.
+func (w *crcWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
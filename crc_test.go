@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCRCReaderWriter(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("a"), sampleText(10000), randomBytes(70000)} {
+		want := crc32.ChecksumIEEE(data)
+
+		// Short reads and writes, like of pipes, still add up.
+		r := &crcReader{in: iotest.HalfReader(bytes.NewReader(data))}
+		var out bytes.Buffer
+		w := &crcWriter{out: &out}
+		if _, err := io.Copy(writerOnly{w}, r); err != nil {
+			t.Fatal(err)
+		}
+		if r.crc != want || r.n != uint64(len(data)) {
+			t.Errorf("%d bytes: reader CRC %08x of %d bytes, want %08x", len(data), r.crc, r.n, want)
+		}
+		if w.crc != want {
+			t.Errorf("%d bytes: writer CRC %08x, want %08x", len(data), w.crc, want)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("%d bytes: passed on other bytes", len(data))
+		}
+	}
+}
+
+func TestCRCWriterShortWrite(t *testing.T) {
+	// Only what the underlying writer took counts.
+	var out bytes.Buffer
+	w := &crcWriter{out: &limitWriter{out: &out, n: 3}}
+	w.Write([]byte("ab"))
+	if _, err := w.Write([]byte("cd")); err == nil {
+		t.Fatal("write past the limit succeeded")
+	}
+	if want := crc32.ChecksumIEEE(out.Bytes()); w.crc != want {
+		t.Fatalf("CRC %08x, want %08x of %q", w.crc, want, out.Bytes())
+	}
+}
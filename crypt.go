@@ -0,0 +1,131 @@
+// Passphrase based encryption of everything following the archive header.
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+)
+
+const (
+	saltSize      = 16     // bytes of random salt stored after the header
+	keyIterations = 600000 // PBKDF2-SHA256 rounds deriving the AES-256 key
+)
+
+// deriveKey derives the AES-256 key from the passphrase.
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, keyIterations, 32)
+}
+
+// newCipher returns the cipher for the flags: AES-GCM when authenticated,
+// otherwise AES-CTR. Nonce size is the size of the GCM nonce or CTR IV.
+func newCipher(flags uint32, passphrase string, salt []byte) (block cipher.Block, aead cipher.AEAD, nonceSize int, err error) {
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if block, err = aes.NewCipher(key); err != nil {
+		return nil, nil, 0, err
+	}
+	if flags&flagAuthenticated == 0 {
+		return block, nil, aes.BlockSize, nil
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, nil, 0, err
+	}
+	return block, aead, aead.NonceSize(), nil
+}
+
+// newEncrypter writes the salt and nonce to out and returns the writer
+// encrypting the rest of the archive into out. Close must be called
+// to write out the sealed data in authenticated mode.
+func newEncrypter(flags uint32, passphrase string, out io.Writer) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	block, aead, nonceSize, err := newCipher(flags, passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	if _, err = out.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err = out.Write(nonce); err != nil {
+		return nil, err
+	}
+
+	if aead == nil {
+		return cipher.StreamWriter{S: cipher.NewCTR(block, nonce), W: out}, nil
+	}
+	return &sealWriter{aead: aead, nonce: nonce, ad: headerBytes(flags), out: out}, nil
+}
+
+// newDecrypter reads the salt and nonce from in and returns the reader
+// decrypting the rest of the archive. In authenticated mode the rest,
+// up to the digest if there is one, is read and verified at once.
+func newDecrypter(flags uint32, passphrase string, in io.Reader) (io.Reader, error) {
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(in, salt); err != nil {
+		return nil, err
+	}
+	block, aead, nonceSize, err := newCipher(flags, passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err = io.ReadFull(in, nonce); err != nil {
+		return nil, err
+	}
+
+	if aead == nil {
+		return cipher.StreamReader{S: cipher.NewCTR(block, nonce), R: in}, nil
+	}
+	sealed, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagDigest != 0 {
+		if len(sealed) < sha256.Size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		sealed = sealed[:len(sealed)-sha256.Size]
+	}
+	plain, err := aead.Open(sealed[:0], nonce, sealed, headerBytes(flags))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// sealWriter collects the data written to it and writes it
+// sealed by AES-GCM on Close.
+type sealWriter struct {
+	aead  cipher.AEAD
+	nonce []byte
+	ad    []byte // authenticated header
+	out   io.Writer
+	buf   bytes.Buffer
+}
+
+// Write implements io.Writer.
+func (w *sealWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close implements io.Closer. It does not close the underlying writer.
+func (w *sealWriter) Close() error {
+	_, err := w.out.Write(w.aead.Seal(nil, w.nonce, w.buf.Bytes(), w.ad))
+	return err
+}
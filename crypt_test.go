@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	in := sampleText(20000)
+	for _, authenticate := range []bool{false, true} {
+		opts := Options{Passphrase: "right", Authenticate: authenticate}
+		archive := roundTrip(t, in, opts)
+		if bytes.Contains(archive, in[:100]) {
+			t.Fatalf("authenticate %v: archive holds plain text", authenticate)
+		}
+
+		var out bytes.Buffer
+		_, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive), &out, Options{Passphrase: "wrong"})
+		if err == nil && bytes.Equal(out.Bytes(), in) {
+			t.Fatalf("authenticate %v: wrong passphrase decrypted the archive", authenticate)
+		}
+		if authenticate && !errors.Is(err, ErrAuthentication) {
+			t.Fatalf("wrong passphrase: got %v, want ErrAuthentication", err)
+		}
+
+		if _, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive), new(bytes.Buffer), Options{}); err != ErrPassphraseRequired {
+			t.Fatalf("authenticate %v, no passphrase: got %v, want ErrPassphraseRequired", authenticate, err)
+		}
+	}
+}
+
+func TestEncryptionTampered(t *testing.T) {
+	archive := roundTrip(t, sampleText(20000), Options{Passphrase: "right", Authenticate: true})
+	for _, at := range []int{headerSize + 20, len(archive) / 2, len(archive) - 1} {
+		tampered := append([]byte(nil), archive...)
+		tampered[at] ^= 1
+		_, err := DecompressWithOptions(context.Background(), bytes.NewReader(tampered), new(bytes.Buffer), Options{Passphrase: "right"})
+		if !errors.Is(err, ErrAuthentication) {
+			t.Errorf("byte %d flipped: got %v, want ErrAuthentication", at, err)
+		}
+	}
+	if _, err := CompressWithOptions(context.Background(), bytes.NewReader(nil), new(bytes.Buffer), Options{Authenticate: true}); err != ErrPassphraseRequired {
+		t.Fatalf("authenticate without passphrase: got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Decoder pulling the bytes of an archive one at a time.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+)
+
+// Decoder decodes a single stream archive on demand, a byte at a time,
+// with no output buffer.
+type Decoder struct {
+	tree    *Leaf
+	reader  Reader
+	base    uint64 // number of archive bits before the ones of reader
+	wide    bool
+	symbols uint64 // number of symbols left to decode
+	odd     bool   // a raw byte follows the symbols in word mode
+	footer  bool   // the valid bit count follows the symbols
+	pending bool   // low byte of the last byte pair is not returned yet
+	low     byte
+	node    *Leaf  // where the walk down the tree for the next symbol got to
+	partial []bool // bits of the walk so far
+}
+
+// DecoderState is the point a Decoder got to, which ResumeDecoder
+// continues from. It holds nothing of the Decoder, so it can be stored.
+type DecoderState struct {
+	Offset  uint64 // number of archive bits consumed
+	Partial []bool // bits of the symbol being decoded read so far
+	Symbols uint64 // number of symbols left to decode
+	Odd     bool   // the odd byte of word mode is not returned yet
+	Pending bool   // the low byte of the last byte pair is not returned yet
+	Low     byte
+}
+
+// NewDecoder reads the header and the dictionary of the archive from src
+// and returns a Decoder of its payload.
+// Only single stream archives without run-length encoding or encryption,
+// and not stored, can be decoded this way, others give ErrUnsupportedFlags.
+func NewDecoder(src io.Reader) (*Decoder, error) {
+	in, ok := src.(*bufio.Reader)
+	if !ok {
+		in = bufio.NewReader(src)
+	}
+
+	prefix, _ := in.Peek(len(magic))
+	flags, err := readHeader(in)
+	if err != nil {
+		return nil, err
+	}
+	if flags&(flagBlocks|flagFiles|flagShared|flagEncrypted|flagRLE|flagStored|flagExternal|flagStatic|flagFooter|flagPermuted) != 0 {
+		return nil, ErrUnsupportedFlags
+	}
+
+	name, err := readName(in, flags)
+	if err != nil {
+		return nil, err
+	}
+	var base uint64
+	if bytes.Equal(prefix, magic[:]) {
+		base = 8 * uint64(headerSize+nameSize(name))
+	}
+
+	reader := NewReader(in)
+	wide := flags&flagWords != 0
+	tree, err := readDictionary(reader, wide, byteOrder(flags))
+	if err != nil {
+		return nil, err
+	}
+	size, err := readFileSize(reader, byteOrder(flags))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Decoder{tree: tree, reader: reader, base: base, wide: wide, symbols: size, footer: flags&flagBitCount != 0, node: tree}
+	if wide {
+		d.symbols, d.odd = size/2, size%2 == 1
+	}
+	return d, nil
+}
+
+// Snapshot returns the point the decoder got to. After an error reading
+// the archive, like io.ErrUnexpectedEOF of an archive still being
+// downloaded, it is the point right before the error, even in the middle
+// of a symbol.
+func (d *Decoder) Snapshot() DecoderState {
+	return DecoderState{
+		Offset:  d.base + d.reader.BitsRead(),
+		Partial: append([]bool(nil), d.partial...),
+		Symbols: d.symbols,
+		Odd:     d.odd,
+		Pending: d.pending,
+		Low:     d.low,
+	}
+}
+
+// ResumeDecoder returns a Decoder continuing the decoding of the archive
+// from the state returned by Snapshot. The header and the dictionary
+// are read again from the beginning of the archive.
+func ResumeDecoder(archive io.ReaderAt, state DecoderState) (*Decoder, error) {
+	d, err := NewDecoder(io.NewSectionReader(archive, 0, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+
+	offset := int64(state.Offset / 8)
+	d.reader = NewReader(io.NewSectionReader(archive, offset, math.MaxInt64-offset))
+	d.base = 8 * uint64(offset)
+	if err = d.reader.SkipBits(state.Offset % 8); err != nil {
+		return nil, err
+	}
+
+	for _, bit := range state.Partial {
+		if d.node = d.node.child(bit); d.node == nil || d.node.Zero == nil && d.node.One == nil {
+			return nil, ErrInvalidState
+		}
+	}
+	d.partial = append(d.partial, state.Partial...)
+	d.symbols, d.odd, d.pending, d.low = state.Symbols, state.Odd, state.Pending, state.Low
+	return d, nil
+}
+
+// NextSymbol returns the next byte of the original data,
+// or io.EOF once all of them are returned.
+func (d *Decoder) NextSymbol() (byte, error) {
+	if d.pending {
+		d.pending = false
+		return d.low, nil
+	}
+	if d.symbols == 0 {
+		if !d.odd {
+			return 0, io.EOF
+		}
+		d.reader.Align()
+		// The symbols are counted, so the valid bit count is of no use.
+		// It is skipped together with the odd byte, so a failed read
+		// leaves nothing half done.
+		bits := uint8(8)
+		if d.footer {
+			bits = 16
+		}
+		v, err := d.reader.PeekBits(bits)
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err = d.reader.SkipBits(uint64(bits)); err != nil {
+			return 0, err
+		}
+		d.odd = false
+		return byte(v >> (bits - 8)), nil
+	}
+
+	// The walk is kept in the decoder, so decoding can resume
+	// in the middle of a symbol.
+	for {
+		bit, err := d.reader.ReadBool()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		next := d.node.child(bit)
+		if next == nil {
+			return 0, ErrCorruptPayload
+		}
+		d.node = next
+		d.partial = append(d.partial, bit)
+		if next.Zero == nil && next.One == nil {
+			break
+		}
+	}
+	leaf := d.node
+	d.node, d.partial = d.tree, d.partial[:0]
+	d.symbols--
+	if d.wide {
+		d.pending, d.low = true, byte(leaf.Value)
+		return byte(leaf.Value >> 8), nil
+	}
+	return byte(leaf.Value), nil
+}
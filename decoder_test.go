@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+// decodeAll returns the bytes d yields until io.EOF.
+func decodeAll(d *Decoder) ([]byte, error) {
+	var out []byte
+	for {
+		value, err := d.NextSymbol()
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return out, err
+		}
+		out = append(out, value)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":  nil,
+		"single": []byte("zzzz"),
+		"text":   sampleText(10001),
+		"random": randomBytes(4096),
+	}
+	for name, in := range inputs {
+		for _, opts := range []Options{{}, {Words: true}, {BitCount: true}, {Words: true, BitCount: true}} {
+			var archive bytes.Buffer
+			if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &archive, opts); err != nil {
+				t.Fatal(err)
+			}
+			var bulk bytes.Buffer
+			if err := Decompress(bytes.NewReader(archive.Bytes()), &bulk); err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := NewDecoder(bytes.NewReader(archive.Bytes()))
+			if err != nil {
+				t.Fatalf("%s %+v: %v", name, opts, err)
+			}
+			got, err := decodeAll(d)
+			if err != nil {
+				t.Fatalf("%s %+v: %v", name, opts, err)
+			}
+			if !bytes.Equal(got, bulk.Bytes()) {
+				t.Errorf("%s %+v: iterating gave %d bytes other than the %d of Decompress", name, opts, len(got), bulk.Len())
+			}
+			// Once done, it stays done.
+			if _, err = d.NextSymbol(); err != io.EOF {
+				t.Errorf("%s %+v: got %v past the end, want io.EOF", name, opts, err)
+			}
+		}
+	}
+}
+
+func TestDecoderResume(t *testing.T) {
+	in := sampleText(20001)
+	for _, words := range []bool{false, true} {
+		var archive bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &archive, Options{Words: words}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Stop halfway, between two symbols or byte pairs.
+		d, err := NewDecoder(bytes.NewReader(archive.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(in)/2+1; i++ {
+			if _, err = d.NextSymbol(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resumed, err := ResumeDecoder(bytes.NewReader(archive.Bytes()), d.Snapshot())
+		if err != nil {
+			t.Fatalf("words %v: %v", words, err)
+		}
+		rest, err := decodeAll(resumed)
+		if err != nil || !bytes.Equal(rest, in[len(in)/2+1:]) {
+			t.Errorf("words %v: resumed halfway to %d bytes, %v", words, len(rest), err)
+		}
+
+		// Stop where the archive downloaded so far ends, likely within a symbol.
+		partial := archive.Bytes()[:archive.Len()/2]
+		d, err = NewDecoder(bytes.NewReader(partial))
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, err := decodeAll(d)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("words %v: got %v at the end of the download, want io.ErrUnexpectedEOF", words, err)
+		}
+		resumed, err = ResumeDecoder(bytes.NewReader(archive.Bytes()), d.Snapshot())
+		if err != nil {
+			t.Fatalf("words %v: %v", words, err)
+		}
+		rest, err = decodeAll(resumed)
+		if err != nil || !bytes.Equal(append(head, rest...), in) {
+			t.Errorf("words %v: resumed download decoded to %d+%d bytes, %v", words, len(head), len(rest), err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Dictionaries kept apart from the archives coded by them.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+)
+
+// BuildDictionary returns the code of every byte value of the histogram,
+// nil for the values it does not hold.
+func BuildDictionary(h Histogram) ([256][]bool, error) {
+	var dict [256][]bool
+	leafs := h.leafs()
+	if len(leafs) == 0 {
+		return dict, nil
+	}
+	tree, err := BuildTree(leafs)
+	if err != nil {
+		return dict, err
+	}
+	copy(dict[:], flatTree(tree, leafs, false))
+	return dict, nil
+}
+
+// ExportDictionary writes dict to w as a version 2 dictionary,
+// for ImportDictionary to read it back.
+func ExportDictionary(dict [256][]bool, w io.Writer) error {
+	count := 0
+	for _, path := range dict {
+		if len(path) > 0 {
+			count++
+		}
+	}
+	writer := NewWriter(w)
+	if _, err := writePaths(dict[:], count, false, binary.BigEndian, writer); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// ImportDictionary reads a dictionary written by ExportDictionary
+// and returns the root of its tree.
+func ImportDictionary(r io.Reader) (*Leaf, error) {
+	return readDictionary(NewReader(r), false, binary.BigEndian)
+}
+
+// CompressWithDictionary is like Compress, but codes src by dict
+// instead of a dictionary built for it, which the archive then goes without.
+// Every byte of src must have a code in dict, or ErrMissingCode is returned.
+// The archive can only be decompressed by DecompressWithDictionary.
+func CompressWithDictionary(src io.Reader, dst io.Writer, dict [256][]bool) error {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	if err = writeHeader(flagExternal, dst); err != nil {
+		return err
+	}
+	return encodePayload(context.Background(), dict[:], data, flagExternal, NewWriter(dst), Options{})
+}
+
+// DecompressWithDictionary decompresses an archive written by
+// CompressWithDictionary from src to dst, decoding it by the tree
+// of the same dictionary, as returned by ImportDictionary.
+// The tree is only read, so a dictionary sent once can be imported once
+// and its tree shared by any number of archives, decoded concurrently or not.
+func DecompressWithDictionary(src io.Reader, dst io.Writer, tree *Leaf) error {
+	in, ok := src.(*bufio.Reader)
+	if !ok {
+		in = bufio.NewReader(src)
+	}
+
+	flags, err := readHeader(in)
+	if err != nil {
+		return err
+	}
+	if flags&flagExternal == 0 {
+		return ErrNotExternal
+	}
+	if flags&(flagBlocks|flagFiles|flagShared|flagEncrypted|flagStored|flagName|flagStatic|flagFooter|flagPermuted) != 0 {
+		return ErrUnsupportedFlags
+	}
+	_, err = decodePayload(context.Background(), tree, NewReader(in), dst, flags, Options{})
+	return err
+}
+
+// DecompressPayload decodes the size bytes of a bare payload coded by the
+// dictionary of tree, as returned by ImportDictionary, and writes them to out.
+// The payload has neither a header nor a size of its own: it is what an
+// archive written by CompressWithDictionary holds after its header and size,
+// so a dictionary sent once can be followed by any number of sized payloads.
+// Like DecompressWithDictionary, it only reads the tree.
+func DecompressPayload(tree *Leaf, size uint64, payload io.Reader, out io.Writer) error {
+	_, err := decompress(context.Background(), tree, size, false, NewReader(payload), NewWriter(out), Options{})
+	return err
+}
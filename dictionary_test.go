@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// dictionaryOf returns the dictionary built for data.
+func dictionaryOf(t *testing.T, data []byte) [256][]bool {
+	t.Helper()
+	h, err := ScanHistogram(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := BuildDictionary(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dict
+}
+
+func TestCompressWithDictionaryMissingCode(t *testing.T) {
+	dict := dictionaryOf(t, []byte("abc"))
+	var archive bytes.Buffer
+	if err := CompressWithDictionary(bytes.NewReader([]byte("abcd")), &archive, dict); err != ErrMissingCode {
+		t.Fatalf("got %v, want ErrMissingCode", err)
+	}
+}
+
+func TestImportedDictionary(t *testing.T) {
+	text := sampleText(20000)
+	dict := dictionaryOf(t, text)
+	var exported bytes.Buffer
+	if err := ExportDictionary(dict, &exported); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ImportDictionary(bytes.NewReader(exported.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range [][]byte{nil, text[:50], text[1000:3000], text} {
+		var archive bytes.Buffer
+		if err := CompressWithDictionary(bytes.NewReader(payload), &archive, dict); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := DecompressWithDictionary(bytes.NewReader(archive.Bytes()), &out, tree); err != nil {
+			t.Fatalf("%d bytes: %v", len(payload), err)
+		}
+		if !bytes.Equal(out.Bytes(), payload) {
+			t.Errorf("%d bytes: round trip gave %d bytes", len(payload), out.Len())
+		}
+
+		// The archive goes without the dictionary the usual one holds.
+		var embedded bytes.Buffer
+		if err := Compress(bytes.NewReader(payload), &embedded); err != nil {
+			t.Fatal(err)
+		}
+		if len(payload) > 0 && archive.Len() >= embedded.Len() {
+			t.Errorf("%d bytes: archive is %d bytes, %d with its own dictionary", len(payload), archive.Len(), embedded.Len())
+		}
+		if err := Decompress(bytes.NewReader(archive.Bytes()), &out); err != ErrDictionaryRequired {
+			t.Errorf("%d bytes: Decompress got %v, want ErrDictionaryRequired", len(payload), err)
+		}
+	}
+}
+
+func TestSharedImportedTree(t *testing.T) {
+	text := sampleText(50000)
+	dict := dictionaryOf(t, text)
+	var exported bytes.Buffer
+	if err := ExportDictionary(dict, &exported); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ImportDictionary(bytes.NewReader(exported.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := make([][]byte, 16)
+	archives := make([][]byte, len(payloads))
+	for i := range payloads {
+		payloads[i] = text[i*1000 : i*1000+500*(i+1)]
+		var archive bytes.Buffer
+		if err := CompressWithDictionary(bytes.NewReader(payloads[i]), &archive, dict); err != nil {
+			t.Fatal(err)
+		}
+		archives[i] = archive.Bytes()
+	}
+
+	// Every archive is decoded by the one tree, all at once.
+	outs := make([]bytes.Buffer, len(archives))
+	errs := make([]error, len(archives))
+	var wg sync.WaitGroup
+	for i := range archives {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = DecompressWithDictionary(bytes.NewReader(archives[i]), &outs[i], tree)
+		}()
+	}
+	wg.Wait()
+	for i := range archives {
+		if errs[i] != nil {
+			t.Errorf("payload %d: %v", i, errs[i])
+		} else if !bytes.Equal(outs[i].Bytes(), payloads[i]) {
+			t.Errorf("payload %d: round trip gave %d bytes, want %d", i, outs[i].Len(), len(payloads[i]))
+		}
+	}
+}
+
+func TestDecompressPayload(t *testing.T) {
+	text := sampleText(20000)
+	dict := dictionaryOf(t, text)
+	var exported bytes.Buffer
+	if err := ExportDictionary(dict, &exported); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ImportDictionary(bytes.NewReader(exported.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range [][]byte{nil, text[:1], text[:50], text[1000:3000], text} {
+		var archive bytes.Buffer
+		if err := CompressWithDictionary(bytes.NewReader(payload), &archive, dict); err != nil {
+			t.Fatal(err)
+		}
+		// The size follows the header, the payload follows the size.
+		size := binary.BigEndian.Uint64(archive.Bytes()[headerSize:])
+		bare := archive.Bytes()[headerSize+8:]
+		var out bytes.Buffer
+		if err := DecompressPayload(tree, size, bytes.NewReader(bare), &out); err != nil {
+			t.Fatalf("%d bytes: %v", len(payload), err)
+		}
+		if !bytes.Equal(out.Bytes(), payload) {
+			t.Errorf("%d bytes: round trip gave %d bytes", len(payload), out.Len())
+		}
+		if len(bare) > 1 {
+			err := DecompressPayload(tree, size, bytes.NewReader(bare[:len(bare)/2]), io.Discard)
+			if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+				t.Errorf("%d bytes: truncated payload got %v, want EOF", len(payload), err)
+			}
+		}
+	}
+}
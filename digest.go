@@ -0,0 +1,56 @@
+// SHA-256 digest of the whole archive, stored as its footer.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"io"
+)
+
+// Verify reads the archive until EOF and checks it against the SHA-256 digest
+// stored at its end, which covers every byte of the archive before it.
+func Verify(archive io.Reader) error {
+	in := bufio.NewReader(archive)
+	flags, err := readHeader(in)
+	if err != nil {
+		return err
+	}
+	if flags&flagDigest == 0 {
+		return ErrNoDigest
+	}
+
+	hash := sha256.New()
+	hash.Write(headerBytes(flags))
+	footer := &tailWriter{out: hash, size: sha256.Size}
+	if _, err = io.Copy(footer, in); err != nil {
+		return err
+	}
+	if len(footer.tail) < sha256.Size {
+		return io.ErrUnexpectedEOF
+	}
+	if !bytes.Equal(hash.Sum(nil), footer.tail) {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// tailWriter passes everything written to it to the underlying io.Writer,
+// except for the last size bytes, which are kept in tail.
+type tailWriter struct {
+	out  io.Writer
+	size int
+	tail []byte
+}
+
+// Write implements io.Writer.
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.tail = append(w.tail, p...)
+	if extra := len(w.tail) - w.size; extra > 0 {
+		if _, err := w.out.Write(w.tail[:extra]); err != nil {
+			return 0, err
+		}
+		w.tail = append(w.tail[:0], w.tail[extra:]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestVerify(t *testing.T) {
+	archive := roundTrip(t, sampleText(10000), Options{Digest: true})
+	if err := Verify(iotest.OneByteReader(bytes.NewReader(archive))); err != nil {
+		t.Fatal(err)
+	}
+	for _, at := range []int{0, headerSize, len(archive) / 2, len(archive) - 1} {
+		corrupt := append([]byte(nil), archive...)
+		corrupt[at] ^= 0x10
+		if err := Verify(bytes.NewReader(corrupt)); err == nil {
+			t.Errorf("byte %d flipped: archive verified", at)
+		}
+	}
+	if err := Verify(bytes.NewReader(archive[:headerSize+10])); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+	}
+	plain := roundTrip(t, sampleText(100), Options{})
+	if err := Verify(bytes.NewReader(plain)); err != ErrNoDigest {
+		t.Errorf("no digest: got %v, want ErrNoDigest", err)
+	}
+}
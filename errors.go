@@ -0,0 +1,202 @@
+// Errors returned by the archiver.
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidBufferSize is returned when Options.BufferSize is negative.
+	ErrInvalidBufferSize = errors.New("buffer size must be positive")
+
+	// ErrUnexpectedAlign is returned when a Writer or a Reader made by WithStrictAlign
+	// is aligned with bits pending.
+	ErrUnexpectedAlign = errors.New("bits pending at alignment")
+
+	// ErrInvalidBitCount is returned when more bits are requested than fit the result.
+	ErrInvalidBitCount = errors.New("invalid bit count")
+
+	// ErrInvalidWorkers is returned when Options.Workers is negative.
+	ErrInvalidWorkers = errors.New("worker count must be positive")
+
+	// ErrInvalidBlockSize is returned when Options.BlockSize is negative.
+	ErrInvalidBlockSize = errors.New("block size must be positive")
+
+	// ErrInvalidSampleSize is returned when Options.SampleSize is negative.
+	ErrInvalidSampleSize = errors.New("sample size must be positive")
+
+	// ErrUnsupportedVersion is returned for an unknown dictionary format version.
+	ErrUnsupportedVersion = errors.New("unsupported dictionary version")
+
+	// ErrUnsupportedByteOrder is returned for a byte order other than big-endian or little-endian.
+	ErrUnsupportedByteOrder = errors.New("unsupported byte order")
+
+	// ErrFrequencyOverflow is returned when a frequency does not fit the dictionary field.
+	ErrFrequencyOverflow = errors.New("symbol frequency overflow")
+
+	// ErrMissingCode is returned when the source holds a symbol missing from the dictionary.
+	ErrMissingCode = errors.New("symbol missing from dictionary")
+
+	// ErrNoLeafs is returned when a tree is built of no leafs at all.
+	ErrNoLeafs = errors.New("no leafs to build a tree of")
+
+	// ErrInvalidLeafs is returned when a tree is built of leafs sharing a value
+	// or with a frequency which is not positive.
+	ErrInvalidLeafs = errors.New("leafs must have distinct values and positive frequencies")
+
+	// ErrCodeTooLong is returned when a code does not fit the dictionary length field.
+	ErrCodeTooLong = errors.New("symbol code too long")
+
+	// ErrCorruptHeader is returned when the dictionary claims more symbols than the alphabet has.
+	ErrCorruptHeader = errors.New("corrupt dictionary header")
+
+	// ErrCorruptDictionary is returned when the dictionary does not form a valid prefix code.
+	ErrCorruptDictionary = errors.New("corrupt dictionary")
+
+	// ErrCorruptPayload is returned when the payload holds a code missing from the dictionary.
+	ErrCorruptPayload = errors.New("corrupt payload")
+
+	// ErrUnsupportedFlags is returned for an archive using features unknown to this version.
+	ErrUnsupportedFlags = errors.New("unsupported archive flags")
+
+	// ErrNotBlockArchive is returned when block access is requested for a stream archive.
+	ErrNotBlockArchive = errors.New("not a block mode archive")
+
+	// ErrBlockOutOfRange is returned when the requested block or range is not in the archive.
+	ErrBlockOutOfRange = errors.New("block out of range")
+
+	// ErrCorruptBlock is returned when a block does not match its index entry.
+	ErrCorruptBlock = errors.New("corrupt block")
+
+	// ErrPassphraseRequired is returned when encryption is used without a passphrase.
+	ErrPassphraseRequired = errors.New("passphrase required")
+
+	// ErrAuthentication is returned when an authenticated archive was modified
+	// or the passphrase is wrong.
+	ErrAuthentication = errors.New("archive authentication failed")
+
+	// ErrEncrypted is returned for random access to an encrypted archive.
+	ErrEncrypted = errors.New("archive is encrypted")
+
+	// ErrNoDigest is returned when verifying an archive written without a digest.
+	ErrNoDigest = errors.New("archive has no digest")
+
+	// ErrDigestMismatch is returned when the archive does not match its digest.
+	ErrDigestMismatch = errors.New("archive digest mismatch")
+
+	// ErrNotFilesArchive is returned when file access is requested for a single file archive.
+	ErrNotFilesArchive = errors.New("not a multi-file archive")
+
+	// ErrFilesArchive is returned when a multi-file archive is decompressed as a single stream.
+	ErrFilesArchive = errors.New("multi-file archive")
+
+	// ErrNotManyArchive is returned when an archive not written by CompressMany
+	// is decompressed by DecompressMany.
+	ErrNotManyArchive = errors.New("not a multi-source archive")
+
+	// ErrManyArchive is returned when an archive written by CompressMany
+	// is decompressed as a single stream.
+	ErrManyArchive = errors.New("multi-source archive")
+
+	// ErrStreamCount is returned when an archive holds a different number
+	// of sources than there are writers for them.
+	ErrStreamCount = errors.New("wrong number of sources")
+
+	// ErrNotAppendable is returned when appending to an archive with a fixed layout.
+	ErrNotAppendable = errors.New("archive cannot be appended to")
+
+	// ErrDuplicateEntry is returned when appending a file already in the archive.
+	ErrDuplicateEntry = errors.New("file already in archive")
+
+	// ErrCorruptTable is returned when the file table does not match the archive.
+	ErrCorruptTable = errors.New("corrupt file table")
+
+	// ErrSharedWords is returned when a shared dictionary is requested in words mode.
+	ErrSharedWords = errors.New("shared dictionary is not available in words mode")
+
+	// ErrInvalidName is returned for a source name which is not a plain file name.
+	ErrInvalidName = errors.New("invalid source name")
+
+	// ErrNoName is returned when the output is to be named after the source,
+	// but the archive does not store its name.
+	ErrNoName = errors.New("archive has no source name")
+
+	// ErrDictionaryRequired is returned when an archive coded by a dictionary
+	// kept apart is decompressed without it.
+	ErrDictionaryRequired = errors.New("archive needs an external dictionary")
+
+	// ErrUnknownStaticTable is returned when a static table id is not known.
+	ErrUnknownStaticTable = errors.New("unknown static table")
+
+	// ErrStaticMode is returned when a static table is requested
+	// in block mode or in words mode.
+	ErrStaticMode = errors.New("static table is not available in block mode or words mode")
+
+	// ErrFooterMode is returned when a size footer is requested
+	// in block mode, words mode, with RLE or with Store.
+	ErrFooterMode = errors.New("size footer is not available in block mode, words mode, with RLE or with Store")
+
+	// ErrCorruptPermutation is returned when a permutation of byte values repeats one.
+	ErrCorruptPermutation = errors.New("corrupt permutation")
+
+	// ErrPermuteMode is returned when a permutation is requested
+	// with a static table or a size footer.
+	ErrPermuteMode = errors.New("permutation is not available with a static table or a size footer")
+
+	// ErrNotRegularFile is returned when a directory is given as the source to compress.
+	ErrNotRegularFile = errors.New("source is not a regular file")
+
+	// ErrChunkingMode is returned when chunking is requested without a block size.
+	ErrChunkingMode = errors.New("chunking requires a block size")
+
+	// ErrNotExternal is returned when an archive with its own dictionary
+	// is decompressed by an external one.
+	ErrNotExternal = errors.New("archive has its own dictionary")
+
+	// ErrInvalidState is returned when resuming decoding from a state
+	// which does not fit the archive.
+	ErrInvalidState = errors.New("invalid decoder state")
+
+	// ErrShortBuffer is returned when the original data does not fit the buffer given.
+	ErrShortBuffer = errors.New("buffer too small")
+
+	// ErrOutputExists is returned when extraction would replace an existing file.
+	ErrOutputExists = errors.New("output file already exists")
+
+	// ErrOutputTooLarge is returned when decompression would write more than Options.MaxOutputSize.
+	ErrOutputTooLarge = errors.New("output too large")
+
+	// ErrChecksumMismatch is returned when decompressed data does not match Options.CRC32,
+	// or the CRC-32 of the size footer.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrVerifyFailed is returned when a written archive does not decompress to its source.
+	ErrVerifyFailed = errors.New("archive verification failed")
+)
+
+// Phases of decoding a stream reported by DecodeError.
+const (
+	PhaseHeader     = "header"     // the size of the stream
+	PhaseDictionary = "dictionary" // the dictionary of the stream
+	PhasePayload    = "payload"    // the coded symbols and the bits following them
+)
+
+// DecodeError is returned when decoding a stream fails on its content.
+// Offset is the number of bits read when decoding failed, counted from the data
+// following the archive header and the stored name, or from the block or the file
+// decoded on its own. The damage is at most that far, usually right before it.
+// Offsets of encrypted archives count decrypted data.
+type DecodeError struct {
+	Phase  string // see PhaseHeader, PhaseDictionary and PhasePayload
+	Offset uint64 // in bits
+	Err    error  // what stopped decoding
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s at bit %d (byte %d): %v", e.Phase, e.Offset, e.Offset/8, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
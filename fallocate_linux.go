@@ -0,0 +1,21 @@
+//go:build linux
+
+// Preallocation of output files by fallocate.
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: allocate without changing the file size.
+const fallocKeepSize = 0x01
+
+// preallocate reserves size bytes of disk space for file, so writing it
+// does not fragment it or run out of space halfway.
+func preallocate(file *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+}
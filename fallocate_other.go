@@ -0,0 +1,11 @@
+//go:build !linux
+
+// Preallocation of output files, not supported on this system.
+package main
+
+import "os"
+
+// preallocate does nothing, the file grows as it is written.
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+// Single files: compressing one file to another.
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// CompressFile compresses the file at srcPath to a new archive at dstPath,
+// written atomically like extracted files, see Options.TempDir.
+// The source is read twice, rewinding it between building the dictionary
+// and coding it, rather than kept in memory. The archive is preallocated
+// as large as the source where the file system supports it, and cut to
+// its size once written. A pipe or a device is read into memory instead,
+// like any stream, and a directory fails with ErrNotRegularFile.
+func CompressFile(srcPath, dstPath string, opts Options) (Stats, error) {
+	if err := opts.validate(); err != nil {
+		return Stats{}, err
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var stats Stats
+	err = writeAtomically(dstPath, opts.TempDir, func(out *os.File) error {
+		// A failed preallocation only costs the speed it was meant to win.
+		if info.Mode().IsRegular() {
+			preallocate(out, info.Size())
+		}
+		if stats, err = CompressWithOptions(context.Background(), src, out, opts); err != nil {
+			return err
+		}
+		size, err := out.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		return out.Truncate(size)
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
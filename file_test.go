@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressFile(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(100000)
+	src := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(src, in, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []Options{{}, {BlockSize: 30000}, {RLE: true}} {
+		dst := filepath.Join(dir, "archive.bee")
+		stats, err := CompressFile(src, dst, opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		archive, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The preallocated tail is cut off.
+		if stats.OriginalSize != uint64(len(in)) || stats.CompressedSize != uint64(len(archive)) {
+			t.Errorf("%+v: stats report %d of %d bytes, want %d of %d", opts, stats.CompressedSize, stats.OriginalSize, len(archive), len(in))
+		}
+		if stats.Symbols == 0 || stats.DictionarySize == 0 || stats.Elapsed <= 0 {
+			t.Errorf("%+v: stats not populated: %+v", opts, stats)
+		}
+
+		var out bytes.Buffer
+		if err := Decompress(bytes.NewReader(archive), &out); err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("%+v: decompressed file differs from the source", opts)
+		}
+	}
+
+	// No temporary file is left beside the archive.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d files in the directory, want the source and the archive", len(entries))
+	}
+}
+
+func TestCompressFileDirectory(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "archive.bee")
+	if _, err := CompressFile(dir, dst, Options{}); err != ErrNotRegularFile {
+		t.Fatalf("got %v, want ErrNotRegularFile", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("archive of a directory left behind: %v", err)
+	}
+}
@@ -0,0 +1,523 @@
+// Multi-file archives: compressed files followed by the file table.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Entry describes one file of a multi-file archive.
+type Entry struct {
+	Name   string // base name of the file
+	Offset uint64 // position of the compressed file in the archive
+	Size   uint64 // number of uncompressed bytes
+}
+
+// CompressFiles writes a multi-file archive of files to dst, each file
+// named by its base name. Block mode, encryption and the digest are not
+// available for multi-file archives.
+// With opts.SharedDictionary the files are read twice: first to build
+// the dictionary from all of them, then to code them. Such an archive
+// cannot be appended to, as new files may not fit the dictionary.
+func CompressFiles(ctx context.Context, dst io.Writer, files []string, opts Options) (Stats, error) {
+	start := time.Now()
+
+	if err := opts.validate(); err != nil {
+		return Stats{}, err
+	}
+
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		name := filepath.Base(file)
+		if names[name] {
+			return Stats{}, ErrDuplicateEntry
+		}
+		names[name] = true
+	}
+
+	flags := flagFiles | opts.byteOrderFlags()
+	if opts.RLE {
+		flags |= flagRLE
+	}
+	if opts.Words {
+		flags |= flagWords
+	}
+	if opts.SharedDictionary {
+		flags |= flagShared
+	}
+
+	out := &countingWriter{out: dst}
+	if err := writeHeader(flags, out); err != nil {
+		return Stats{}, err
+	}
+
+	var stats Stats
+	var dict [][]bool
+	if flags&flagShared != 0 {
+		hs := make([]Histogram, len(files))
+		for i, file := range files {
+			source, err := readSource(file, flags)
+			if err != nil {
+				return Stats{}, err
+			}
+			if hs[i], err = ScanHistogram(bytes.NewReader(source)); err != nil {
+				return Stats{}, err
+			}
+		}
+
+		leafs := MergeHistograms(hs...).leafs()
+		version := opts.dictionaryVersion()
+		if version == 1 {
+			scaleFrequencies(leafs, math.MaxUint32)
+		}
+		dict = make([][]bool, alphabet(false))
+		if len(leafs) > 0 {
+			tree, err := BuildTree(leafs)
+			if err != nil {
+				return Stats{}, err
+			}
+			dict = flatTree(tree, leafs, false)
+		}
+
+		writer := NewWriter(out)
+		dictSize, err := writeDictionary(version, leafs, dict, false, byteOrder(flags), writer)
+		if err != nil {
+			return Stats{}, err
+		}
+		if err = writer.Close(); err != nil {
+			return Stats{}, err
+		}
+		stats.DictionarySize = uint64(dictSize)
+	}
+
+	var symbols [256]bool
+	entries := make([]Entry, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return Stats{}, err
+		}
+
+		base := stats.OriginalSize
+		fileOpts := opts
+		fileOpts.Progress = func(processed, _ uint64) {
+			opts.progress(base+processed, 0)
+		}
+
+		offset := out.n
+		if flags&flagShared != 0 {
+			source := data
+			if flags&flagRLE != 0 {
+				source = rleEncode(data)
+			}
+			err = encodePayload(ctx, dict, source, flags, NewWriter(out), fileOpts)
+		} else {
+			var fileStats Stats
+			fileStats, err = encode(ctx, data, out, flags, fileOpts)
+			stats.DictionarySize += fileStats.DictionarySize
+		}
+		if err != nil {
+			return Stats{}, err
+		}
+
+		entries = append(entries, Entry{Name: filepath.Base(file), Offset: offset, Size: uint64(len(data))})
+		stats.OriginalSize += uint64(len(data))
+		for _, value := range data {
+			symbols[value] = true
+		}
+	}
+	for _, present := range symbols {
+		if present {
+			stats.Symbols++
+		}
+	}
+
+	if err := writeEntries(entries, out.n, byteOrder(flags), out); err != nil {
+		return Stats{}, err
+	}
+
+	stats.CompressedSize = out.n
+	stats.Expanded = stats.CompressedSize > stats.OriginalSize
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// readSource reads the file and applies the pre-passes selected by flags.
+func readSource(file string, flags uint32) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil || flags&flagRLE == 0 {
+		return data, err
+	}
+	return rleEncode(data), nil
+}
+
+// AppendFile compresses newFile and adds it to the archive at archivePath,
+// creating the archive if it does not exist.
+// Files already in the archive are not rewritten: the new file takes
+// the place of the file table, which is written again after it.
+// A single file archive is upgraded to a multi-file one, its file named
+// after the archive, which takes decoding it once to learn its size.
+func AppendFile(archivePath, newFile string) error {
+	data, err := os.ReadFile(newFile)
+	if err != nil {
+		return err
+	}
+
+	archive, err := os.OpenFile(archivePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	flags, entries, end, err := openEntries(archive, archivePath)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(newFile)
+	for _, entry := range entries {
+		if entry.Name == name {
+			return ErrDuplicateEntry
+		}
+	}
+
+	if _, err = archive.Seek(int64(end), io.SeekStart); err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(archive)
+	out := &countingWriter{out: buffered}
+	if _, err = encode(context.Background(), data, out, flags, Options{}); err != nil {
+		return err
+	}
+	entries = append(entries, Entry{Name: name, Offset: end, Size: uint64(len(data))})
+
+	if err = writeEntries(entries, end+out.n, byteOrder(flags), buffered); err != nil {
+		return err
+	}
+	if err = buffered.Flush(); err != nil {
+		return err
+	}
+	// The header is written last, so a failed upgrade leaves a readable archive.
+	if _, err = archive.WriteAt(headerBytes(flags), 0); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// openEntries returns the flags, the files and the end of the files
+// of the archive to append to, writing the header to an empty archive.
+// The flags returned always include flagFiles.
+func openEntries(archive *os.File, archivePath string) (uint32, []Entry, uint64, error) {
+	info, err := archive.Stat()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	size := info.Size()
+	if size == 0 {
+		if err = writeHeader(flagFiles, archive); err != nil {
+			return 0, nil, 0, err
+		}
+		return flagFiles, nil, uint64(headerSize), nil
+	}
+
+	header := make([]byte, headerSize)
+	if _, err = archive.ReadAt(header, 0); err != nil || !bytes.Equal(header[:len(magic)], magic[:]) {
+		return 0, nil, 0, ErrNotAppendable
+	}
+	flags := binary.BigEndian.Uint32(header[len(magic):])
+	if flags&^knownFlags != 0 {
+		return 0, nil, 0, ErrUnsupportedFlags
+	}
+	if flags&(flagBlocks|flagEncrypted|flagDigest|flagShared|flagStored|flagExternal|flagStatic|flagFooter) != 0 {
+		return 0, nil, 0, ErrNotAppendable
+	}
+
+	if flags&flagFiles != 0 {
+		entries, end, err := readEntries(archive, size, byteOrder(flags))
+		return flags, entries, end, err
+	}
+
+	// The stored name of the source names its entry, it is not needed
+	// after the header any more.
+	in := bufio.NewReader(io.NewSectionReader(archive, int64(headerSize), size-int64(headerSize)))
+	name, err := readName(in, flags)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	offset := uint64(headerSize + nameSize(name))
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(archivePath), filepath.Ext(archivePath))
+	}
+	length, err := decode(context.Background(), NewReader(in), io.Discard, flags, Options{})
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	entries := []Entry{{Name: name, Offset: offset, Size: length}}
+	return flags&^flagName | flagFiles, entries, uint64(size), nil
+}
+
+// writeEntries writes the file table followed by its offset.
+func writeEntries(entries []Entry, offset uint64, order binary.ByteOrder, writer io.Writer) error {
+	var table bytes.Buffer
+	binary.Write(&table, order, uint32(len(entries)))
+	for _, entry := range entries {
+		binary.Write(&table, order, uint16(len(entry.Name)))
+		table.WriteString(entry.Name)
+		binary.Write(&table, order, entry.Offset)
+		binary.Write(&table, order, entry.Size)
+	}
+	binary.Write(&table, order, offset)
+	_, err := writer.Write(table.Bytes())
+	return err
+}
+
+// readEntries reads the file table located by the offset at the end
+// of the archive and returns the files and the offset of the table.
+func readEntries(archive io.ReaderAt, size int64, order binary.ByteOrder) ([]Entry, uint64, error) {
+	if size < int64(headerSize)+8 {
+		return nil, 0, ErrCorruptTable
+	}
+	trailer := make([]byte, 8)
+	if _, err := archive.ReadAt(trailer, size-8); err != nil {
+		return nil, 0, err
+	}
+	offset := order.Uint64(trailer)
+	if offset < uint64(headerSize) || offset > uint64(size-8) {
+		return nil, 0, ErrCorruptTable
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(archive, int64(offset), size-8-int64(offset)))
+	var count uint32
+	if err := binary.Read(reader, order, &count); err != nil {
+		return nil, 0, ErrCorruptTable
+	}
+	var entries []Entry
+	for i := uint32(0); i < count; i++ {
+		var length uint16
+		if err := binary.Read(reader, order, &length); err != nil {
+			return nil, 0, ErrCorruptTable
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return nil, 0, ErrCorruptTable
+		}
+		entry := Entry{Name: string(name)}
+		if err := binary.Read(reader, order, &entry.Offset); err != nil {
+			return nil, 0, ErrCorruptTable
+		}
+		if err := binary.Read(reader, order, &entry.Size); err != nil {
+			return nil, 0, ErrCorruptTable
+		}
+		// Names come from the archive, so they must not lead out of the target directory.
+		if !validName(entry.Name) || entry.Offset < uint64(headerSize) || entry.Offset >= offset {
+			return nil, 0, ErrCorruptTable
+		}
+		entries = append(entries, entry)
+	}
+	return entries, offset, nil
+}
+
+// validName reports whether name is a plain file name.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// ReadEntries returns the files of a multi-file archive of the given size.
+func ReadEntries(archive io.ReaderAt, size int64) ([]Entry, error) {
+	_, entries, err := readFiles(archive, size)
+	return entries, err
+}
+
+// readFiles reads the header and the file table of a multi-file archive.
+func readFiles(archive io.ReaderAt, size int64) (uint32, []Entry, error) {
+	flags, err := entriesFlags(archive)
+	if err != nil {
+		return 0, nil, err
+	}
+	entries, _, err := readEntries(archive, size, byteOrder(flags))
+	return flags, entries, err
+}
+
+// entriesFlags reads the header of a multi-file archive.
+func entriesFlags(archive io.ReaderAt) (uint32, error) {
+	flags, err := readHeader(bufio.NewReader(io.NewSectionReader(archive, 0, int64(headerSize))))
+	if err != nil {
+		return 0, err
+	}
+	if flags&flagFiles == 0 {
+		return 0, ErrNotFilesArchive
+	}
+	return flags, nil
+}
+
+// DecompressEntry decompresses a single file of a multi-file archive.
+func DecompressEntry(archive io.ReaderAt, entry Entry, dst io.Writer) error {
+	flags, err := entriesFlags(archive)
+	if err != nil {
+		return err
+	}
+	tree, err := sharedTree(archive, flags)
+	if err != nil {
+		return err
+	}
+	return decodeEntry(archive, entry, tree, flags, dst)
+}
+
+// sharedTree reads the dictionary of an archive with a shared one,
+// otherwise it returns nil.
+func sharedTree(archive io.ReaderAt, flags uint32) (*Leaf, error) {
+	if flags&flagShared == 0 {
+		return nil, nil
+	}
+	reader := NewReader(io.NewSectionReader(archive, int64(headerSize), math.MaxInt64-int64(headerSize)))
+	return readDictionary(reader, false, byteOrder(flags))
+}
+
+// decodeEntry decodes a single file, coded by tree if it is not nil.
+func decodeEntry(archive io.ReaderAt, entry Entry, tree *Leaf, flags uint32, dst io.Writer) error {
+	reader := NewReader(io.NewSectionReader(archive, int64(entry.Offset), math.MaxInt64-int64(entry.Offset)))
+	var size uint64
+	var err error
+	if tree != nil {
+		size, err = decodePayload(context.Background(), tree, reader, dst, flags, Options{})
+	} else {
+		size, err = decode(context.Background(), reader, dst, flags, Options{})
+	}
+	if err != nil {
+		return err
+	}
+	if size != entry.Size {
+		return ErrCorruptTable
+	}
+	return nil
+}
+
+// ExtractFiles decompresses every file of the multi-file archive
+// at archivePath into dir, creating dir if needed.
+// Nothing is extracted if any of the files already exists in dir.
+func ExtractFiles(archivePath, dir string) error {
+	return ExtractFilesWithOptions(archivePath, dir, Options{})
+}
+
+// ExtractFilesWithOptions is like ExtractFiles, but existing files
+// are replaced if opts.Force is set.
+func ExtractFilesWithOptions(archivePath, dir string, opts Options) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	info, err := archive.Stat()
+	if err != nil {
+		return err
+	}
+	flags, entries, err := readFiles(archive, info.Size())
+	if err != nil {
+		return err
+	}
+	tree, err := sharedTree(archive, flags)
+	if err != nil {
+		return err
+	}
+
+	if !opts.Force {
+		for _, entry := range entries {
+			if err = checkOutput(filepath.Join(dir, entry.Name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = extractEntry(archive, entry, tree, flags, filepath.Join(dir, entry.Name), opts.TempDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOutput returns ErrOutputExists if there is a file at path.
+func checkOutput(path string) error {
+	_, err := os.Lstat(path)
+	if err == nil {
+		return ErrOutputExists
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// extractEntry decompresses a single file of the archive to path,
+// through a temporary file in tempDir if it is not empty.
+func extractEntry(archive io.ReaderAt, entry Entry, tree *Leaf, flags uint32, path string, tempDir string) error {
+	return writeAtomically(path, tempDir, func(out *os.File) error {
+		buffered := bufio.NewWriter(out)
+		if err := decodeEntry(archive, entry, tree, flags, buffered); err != nil {
+			return err
+		}
+		return buffered.Flush()
+	})
+}
+
+// writeAtomically calls write with a temporary file in dir, or next to path
+// if dir is empty, and renames it to path once write succeeds. Otherwise
+// the temporary file is removed, so a failed run leaves no partial output behind.
+// A temporary file on another file system than path cannot be renamed,
+// so it is copied next to path first.
+func writeAtomically(path string, dir string, write func(out *os.File) error) error {
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	out, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+
+	if err = out.Chmod(0644); err != nil {
+		return err
+	}
+	if err = write(out); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(out.Name(), path); errors.Is(err, syscall.EXDEV) {
+		// The copy is renamed instead, the temporary file is removed on return.
+		return writeAtomically(path, "", func(local *os.File) error {
+			temp, err := os.Open(out.Name())
+			if err != nil {
+				return err
+			}
+			defer temp.Close()
+			_, err = io.Copy(local, temp)
+			return err
+		})
+	} else if err != nil {
+		return err
+	}
+	done = true
+	return nil
+}
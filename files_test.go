@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeFile writes data to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkFiles fails t unless dir holds files with the given contents.
+func checkFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: extracted %d bytes differ from the %d written", name, len(got), len(want))
+		}
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"first":  sampleText(5000),
+		"second": randomBytes(3000),
+		"third":  []byte("third"),
+	}
+	// Appending to a single file archive turns it into a multi-file one.
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(files["first"]), &archive); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := writeFile(t, dir, "first.bzz", archive.Bytes())
+	for _, name := range []string{"second", "third"} {
+		if err := AppendFile(archivePath, writeFile(t, dir, name, files[name])); err != nil {
+			t.Fatalf("append %s: %v", name, err)
+		}
+	}
+	if err := AppendFile(archivePath, filepath.Join(dir, "third")); err != ErrDuplicateEntry {
+		t.Fatalf("appending a file twice: got %v, want ErrDuplicateEntry", err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := ExtractFiles(archivePath, out); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, out, files)
+	entries, err := os.ReadDir(out)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("%d files extracted, want 3: %v", len(entries), err)
+	}
+}
+
+func TestAppendFileNew(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "new.bzz")
+	files := map[string][]byte{"a": sampleText(100), "b": nil}
+	for _, name := range []string{"a", "b"} {
+		if err := AppendFile(archivePath, writeFile(t, dir, name, files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out")
+	if err := ExtractFiles(archivePath, out); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, out, files)
+}
+
+func TestSharedDictionary(t *testing.T) {
+	dir := t.TempDir()
+	text := sampleText(40000)
+	files := make(map[string][]byte)
+	var paths []string
+	for i := 0; i < 20; i++ {
+		name := "file" + strconv.Itoa(i)
+		files[name] = text[i*2000 : (i+1)*2000]
+		paths = append(paths, writeFile(t, dir, name, files[name]))
+	}
+
+	sizes := make(map[bool]uint64)
+	for _, shared := range []bool{false, true} {
+		archivePath := filepath.Join(dir, "shared"+strconv.FormatBool(shared)+".bzz")
+		archive, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := CompressFiles(context.Background(), archive, paths, Options{SharedDictionary: shared})
+		archive.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[shared] = stats.CompressedSize
+
+		out := filepath.Join(dir, "out"+strconv.FormatBool(shared))
+		if err := ExtractFiles(archivePath, out); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, out, files)
+	}
+	if sizes[true] >= sizes[false] {
+		t.Fatalf("shared dictionary archive is %d bytes, %d with a dictionary per file", sizes[true], sizes[false])
+	}
+}
+
+func TestWriteAtomicallyFailure(t *testing.T) {
+	dir := t.TempDir()
+	failure := errors.New("failed halfway")
+	write := func(out *os.File) error {
+		if _, err := out.Write(sampleText(1000)); err != nil {
+			return err
+		}
+		return failure
+	}
+
+	path := filepath.Join(dir, "new.bzz")
+	if err := writeAtomically(path, "", write); err != failure {
+		t.Fatalf("got %v, want the error of write", err)
+	}
+	// An existing file is left as it was.
+	old := writeFile(t, dir, "old.bzz", []byte("old"))
+	if err := writeAtomically(old, "", write); err != failure {
+		t.Fatalf("got %v, want the error of write", err)
+	}
+	checkFiles(t, dir, map[string][]byte{"old.bzz": []byte("old")})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		for _, entry := range entries {
+			t.Errorf("left behind: %s", entry.Name())
+		}
+	}
+}
+
+func TestExtractExisting(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "files.bzz")
+	files := map[string][]byte{"a": sampleText(100), "b": randomBytes(100)}
+	for _, name := range []string{"a", "b"} {
+		if err := AppendFile(archivePath, writeFile(t, dir, name, files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, out, "b", []byte("keep"))
+	if err := ExtractFiles(archivePath, out); err != ErrOutputExists {
+		t.Fatalf("got %v, want ErrOutputExists", err)
+	}
+	// Nothing is extracted, not even the files which did not exist.
+	checkFiles(t, out, map[string][]byte{"b": []byte("keep")})
+	if _, err := os.Stat(filepath.Join(out, "a")); !os.IsNotExist(err) {
+		t.Fatalf("a extracted along a refused b: %v", err)
+	}
+
+	if err := ExtractFilesWithOptions(archivePath, out, Options{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, out, files)
+}
+
+func TestCrossDeviceTempDir(t *testing.T) {
+	// On Linux /dev/shm is a file system of its own, so the temporary
+	// files cannot be renamed into the output directory.
+	if _, err := os.Stat("/dev/shm"); err != nil {
+		t.Skip("no /dev/shm for temporary files")
+	}
+	tempDir, err := os.MkdirTemp("/dev/shm", "bee-test-")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dir := t.TempDir()
+	in := sampleText(10000)
+	source := writeFile(t, dir, "source", in)
+	archivePath := filepath.Join(dir, "source.bzz")
+	createArchive(source, archivePath, Options{Workers: 1, TempDir: tempDir}, false)
+	out := filepath.Join(dir, "out")
+	extractArchive(archivePath, out, Options{Workers: 1, TempDir: tempDir}, false)
+	checkFiles(t, dir, map[string][]byte{"out": in})
+
+	for _, d := range []string{dir, tempDir} {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				t.Errorf("temporary file %s left in %s", entry.Name(), d)
+			}
+		}
+	}
+}
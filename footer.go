@@ -0,0 +1,50 @@
+// Size footer: the size of a stream following its payload.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// footerSize is the number of bytes taken by the size footer.
+const footerSize = 8 + 4
+
+// writeFooter writes the size of the source and its CRC-32.
+func writeFooter(size uint64, crc uint32, order binary.ByteOrder, writer io.Writer) error {
+	footer := make([]byte, footerSize)
+	order.PutUint64(footer, size)
+	order.PutUint32(footer[8:], crc)
+	_, err := writer.Write(footer)
+	return err
+}
+
+// footerStream takes a stream with the size footer, with the dictionary
+// unless it is coded by a static table, and returns it with the size moved
+// before the payload, as it is without the footer, along with the CRC-32
+// of the source from the footer.
+func footerStream(stream []byte, flags uint32) ([]byte, uint32, error) {
+	if len(stream) < footerSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	order := byteOrder(flags)
+	footer := stream[len(stream)-footerSize:]
+	stream = stream[:len(stream)-footerSize]
+
+	// The dictionary ends aligned, the payload starts right after it.
+	var dictSize uint64
+	if flags&flagStatic == 0 {
+		reader := NewReader(bytes.NewReader(stream))
+		if _, err := readDictionary(reader, false, order); err != nil {
+			return nil, 0, err
+		}
+		reader.Align()
+		dictSize = reader.BitsRead() / 8
+	}
+
+	moved := make([]byte, 0, len(stream)+8)
+	moved = append(moved, stream[:dictSize]...)
+	moved = append(moved, footer[:8]...)
+	moved = append(moved, stream[dictSize:]...)
+	return moved, order.Uint32(footer[8:]), nil
+}
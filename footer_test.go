@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+func TestSizeFooter(t *testing.T) {
+	in := sampleText(50000)
+	for _, opts := range []Options{{SizeFooter: true}, {SizeFooter: true, StaticTable: StaticEnglish}} {
+		// A pipe can be neither rewound nor sized, the archive only appended to.
+		src, feed := io.Pipe()
+		go func() {
+			feed.Write(in)
+			feed.Close()
+		}()
+		var archive bytes.Buffer
+		stats, err := CompressWithOptions(context.Background(), src, writerOnly{&archive}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.OriginalSize != uint64(len(in)) || stats.CRC32 != crc32.ChecksumIEEE(in) {
+			t.Errorf("%+v: stats tell %d bytes of CRC %08x", opts, stats.OriginalSize, stats.CRC32)
+		}
+
+		info, err := ReadInfo(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+		if err != nil || !info.Footer || info.OriginalSize != uint64(len(in)) {
+			t.Errorf("%+v: info tells footer %v, %d bytes, %v", opts, info.Footer, info.OriginalSize, err)
+		}
+		var out bytes.Buffer
+		if _, err = DecompressWithOptions(context.Background(), io.MultiReader(bytes.NewReader(archive.Bytes())), &out, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("%+v: round trip changed the data", opts)
+		}
+
+		// The CRC is the last field of the footer.
+		damaged := bytes.Clone(archive.Bytes())
+		damaged[len(damaged)-1] ^= 1
+		if _, err = DecompressWithOptions(context.Background(), bytes.NewReader(damaged), io.Discard, opts); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("%+v: damaged CRC: got %v, want ErrChecksumMismatch", opts, err)
+		}
+	}
+}
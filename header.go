@@ -0,0 +1,125 @@
+// Archive header definition and (de)serialization.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// magic starts every archive. Legacy archives have no header
+// and start with the dictionary version instead.
+var magic = [3]byte{'B', 'Z', 'Z'}
+
+// Header flags, describing the layout following the header.
+const (
+	// flagBlocks marks the payload split into independently compressed blocks.
+	flagBlocks uint32 = 1 << iota
+
+	// flagRLE marks every stream run-length encoded before Huffman coding.
+	flagRLE
+
+	// flagWords marks byte pairs coded as symbols instead of single bytes.
+	flagWords
+
+	// flagEncrypted marks everything after the header encrypted by AES-256,
+	// preceded by the key salt and the nonce.
+	flagEncrypted
+
+	// flagAuthenticated marks encryption by AES-GCM rather than AES-CTR,
+	// so any modification of the archive is detected.
+	flagAuthenticated
+
+	// flagDigest marks the archive followed by the SHA-256 digest of it.
+	flagDigest
+
+	// flagFiles marks separately compressed named files followed by the file table.
+	flagFiles
+
+	// flagShared marks the files, or without flagFiles the sources,
+	// coded by one dictionary following the header.
+	flagShared
+
+	// flagLittleEndian marks multi-byte fields following the header little-endian.
+	flagLittleEndian
+
+	// flagBitCount marks every payload followed by the number of its valid bits
+	// in its last byte.
+	flagBitCount
+
+	// flagStored marks the source kept as is instead of a stream,
+	// as it does not compress.
+	flagStored
+
+	// flagName marks the header followed by the original name of the source.
+	flagName
+
+	// flagExternal marks a stream without a dictionary, coded by one kept apart.
+	flagExternal
+
+	// flagStatic marks a stream without a dictionary, coded by the static table
+	// whose id follows the header.
+	flagStatic
+
+	// flagFooter marks the size of the stream and the CRC-32 of the source
+	// following the payload instead of preceding it.
+	flagFooter
+
+	// flagPermuted marks every stream preceded by a permutation of the byte values
+	// applied before coding.
+	flagPermuted
+
+	// knownFlags are the flags this version can read.
+	knownFlags = flagBlocks | flagRLE | flagWords | flagEncrypted | flagAuthenticated | flagDigest | flagFiles | flagShared | flagLittleEndian | flagBitCount | flagStored | flagName | flagExternal | flagStatic | flagFooter | flagPermuted
+)
+
+// byteOrder returns the byte order of the multi-byte fields following the header.
+// The header itself is always big-endian.
+func byteOrder(flags uint32) binary.ByteOrder {
+	if flags&flagLittleEndian != 0 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// headerBytes returns the header for the flags.
+func headerBytes(flags uint32) []byte {
+	header := make([]byte, headerSize)
+	copy(header, magic[:])
+	binary.BigEndian.PutUint32(header[len(magic):], flags)
+	return header
+}
+
+// writeHeader writes the magic followed by the flags.
+func writeHeader(flags uint32, writer io.Writer) error {
+	_, err := writer.Write(headerBytes(flags))
+	return err
+}
+
+// readHeader reads the magic and returns the flags following it.
+// A legacy archive is reported with no flags and nothing consumed.
+func readHeader(reader *bufio.Reader) (uint32, error) {
+	prefix, err := reader.Peek(len(magic))
+	if err != nil && len(prefix) == 0 {
+		return 0, err
+	}
+	if !bytes.Equal(prefix, magic[:]) {
+		return 0, nil
+	}
+	if _, err = reader.Discard(len(magic)); err != nil {
+		return 0, err
+	}
+
+	var flags uint32
+	if err = binary.Read(reader, binary.BigEndian, &flags); err != nil {
+		return 0, err
+	}
+	if flags&^knownFlags != 0 {
+		return 0, ErrUnsupportedFlags
+	}
+	return flags, nil
+}
+
+// headerSize is the number of bytes taken by the header.
+const headerSize = len(magic) + 4
@@ -0,0 +1,104 @@
+// Byte frequency histograms, which can be gathered separately and merged.
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+)
+
+// Histogram holds the number of occurrences of every byte value.
+type Histogram [256]uint64
+
+// Scan reads src until EOF and returns the number of occurrences
+// of every byte value.
+func Scan(src io.Reader) ([256]uint64, error) {
+	var freqs [256]uint64
+	err := count(context.Background(), src, &freqs, Options{})
+	return freqs, err
+}
+
+// ScanHistogram is like Scan, but returns a Histogram.
+func ScanHistogram(src io.Reader) (Histogram, error) {
+	return Scan(src)
+}
+
+// Scanner counts byte frequencies of data arriving in pieces,
+// so it does not have to be kept for building the tree.
+// The zero value is ready to use.
+type Scanner struct {
+	hist Histogram
+}
+
+// Add counts the bytes of p.
+func (s *Scanner) Add(p []byte) {
+	addCounts((*[256]uint64)(&s.hist), p)
+}
+
+// Histogram returns the frequencies counted so far.
+func (s *Scanner) Histogram() Histogram {
+	return s.hist
+}
+
+// Leaves returns a leaf for every byte value counted so far, the leafs
+// scanning all the pieces at once gives.
+func (s *Scanner) Leaves() []*Leaf {
+	return s.hist.leafs()
+}
+
+// MergeHistograms returns the histogram of all the sources of hs together.
+func MergeHistograms(hs ...Histogram) Histogram {
+	var merged Histogram
+	for _, h := range hs {
+		for value, freq := range h {
+			merged[value] += freq
+		}
+	}
+	return merged
+}
+
+// leafs returns a leaf for every byte value present in the histogram.
+func (h Histogram) leafs() []*Leaf {
+	return leavesOf(h[:])
+}
+
+// buildTreeFromHistogram returns the root of the tree built for the histogram,
+// the same tree scanning the source gives. It is nil for an empty histogram.
+func buildTreeFromHistogram(h Histogram) (*Leaf, error) {
+	leafs := h.leafs()
+	if len(leafs) == 0 {
+		return nil, nil
+	}
+	return BuildTree(leafs)
+}
+
+// Entropy returns the Shannon entropy of the histogram in bits per byte,
+// the lower bound of the average code length any byte coding can reach.
+func Entropy(hist [256]uint64) float64 {
+	var total uint64
+	for _, freq := range hist {
+		total += freq
+	}
+	if total == 0 {
+		return 0
+	}
+
+	var entropy float64
+	for _, freq := range hist {
+		if freq > 0 {
+			p := float64(freq) / float64(total)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// IdealCompressedSize returns the number of bytes the source of the histogram
+// takes when coded at its entropy, dictionary not included.
+func IdealCompressedSize(hist [256]uint64) uint64 {
+	var total uint64
+	for _, freq := range hist {
+		total += freq
+	}
+	return uint64(math.Ceil(Entropy(hist) * float64(total) / 8))
+}
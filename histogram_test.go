@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMergeHistograms(t *testing.T) {
+	first, second := sampleText(7000), randomBytes(3000)
+	h1, err := ScanHistogram(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := ScanHistogram(bytes.NewReader(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole, err := ScanHistogram(bytes.NewReader(append(append([]byte(nil), first...), second...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := MergeHistograms(h1, h2)
+	if merged != whole {
+		t.Fatal("merged histogram differs from the histogram of the concatenation")
+	}
+
+	root, err := buildTreeFromHistogram(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, _ := treeOf(t, append(append([]byte(nil), first...), second...))
+	if !reflect.DeepEqual(Codes(root), Codes(want)) {
+		t.Fatal("tree of the merged histogram differs from the tree of the concatenation")
+	}
+	if root, err := buildTreeFromHistogram(Histogram{}); root != nil || err != nil {
+		t.Fatalf("empty histogram: got %v, %v", root, err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	in := append(sampleText(10000), randomBytes(10000)...)
+	got, err := Scan(iotest.HalfReader(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [256]uint64
+	for _, value := range in {
+		want[value]++
+	}
+	if got != want {
+		t.Fatal("Scan counts differ from counting byte by byte")
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	var even [256]uint64
+	for i := range even {
+		even[i] = 3
+	}
+	tests := []struct {
+		name    string
+		hist    [256]uint64
+		entropy float64
+		ideal   uint64
+	}{
+		{"empty", [256]uint64{}, 0, 0},
+		{"one symbol", [256]uint64{'a': 100}, 0, 0},
+		{"two even", [256]uint64{'a': 8, 'b': 8}, 1, 2},
+		{"four even", [256]uint64{'a': 1, 'b': 1, 'c': 1, 'd': 1}, 2, 1},
+		// -(1/2 log2 1/2 + 2 * 1/4 log2 1/4) = 1.5 bits, 12 bits over 8 bytes.
+		{"halves and quarters", [256]uint64{'a': 4, 'b': 2, 'c': 2}, 1.5, 2},
+		{"all even", even, 8, 3 * 256},
+	}
+	for _, tt := range tests {
+		if got := Entropy(tt.hist); math.Abs(got-tt.entropy) > 1e-12 {
+			t.Errorf("%s: entropy %v, want %v", tt.name, got, tt.entropy)
+		}
+		if got := IdealCompressedSize(tt.hist); got != tt.ideal {
+			t.Errorf("%s: ideal size %d, want %d", tt.name, got, tt.ideal)
+		}
+	}
+}
+
+func TestScannerChunks(t *testing.T) {
+	data := append(sampleText(10000), randomBytes(777)...)
+	var blob Scanner
+	blob.Add(data)
+
+	// Chunks of uneven sizes, empty ones included.
+	var chunked Scanner
+	for start, size := 0, 0; start < len(data); size = (size*7 + 3) % 1000 {
+		end := min(start+size, len(data))
+		chunked.Add(data[start:end])
+		start = end
+	}
+
+	if chunked.Histogram() != blob.Histogram() {
+		t.Fatal("chunks counted other frequencies than one blob")
+	}
+	if !reflect.DeepEqual(chunked.Leaves(), blob.Leaves()) {
+		t.Fatal("chunks gave other leafs than one blob")
+	}
+	scanned, err := ScanHistogram(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blob.Leaves(), scanned.leafs()) {
+		t.Fatal("scanner leafs differ from the leafs of scanning the data")
+	}
+}
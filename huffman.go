@@ -0,0 +1,720 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+)
+
+type Leaf struct {
+	Value     uint16
+	Frequency uint64
+	Zero      *Leaf
+	One       *Leaf
+	Bit       bool
+	Parent    *Leaf
+}
+
+// child returns the One child for a 1 bit, the Zero one otherwise.
+func (l *Leaf) child(bit bool) *Leaf {
+	if bit {
+		return l.One
+	}
+	return l.Zero
+}
+
+// BufferSize is the default size of the chunks the source is read in.
+const BufferSize = 4096
+
+// Symbol alphabet sizes: single bytes, or byte pairs in word mode.
+const (
+	byteAlphabet = 1 << 8
+	wordAlphabet = 1 << 16
+)
+
+// alphabet returns the number of possible symbols.
+func alphabet(wide bool) int {
+	if wide {
+		return wordAlphabet
+	}
+	return byteAlphabet
+}
+
+func scan(ctx context.Context, reader io.Reader, opts Options) ([]*Leaf, error) {
+	var freqs [256]uint64
+	if err := count(ctx, reader, &freqs, opts); err != nil {
+		return nil, err
+	}
+	return leavesOf(freqs[:]), nil
+}
+
+// scanWords is like scan, but counts big-endian byte pairs of data.
+// A trailing odd byte is not counted.
+func scanWords(ctx context.Context, data []byte, opts Options) ([]*Leaf, error) {
+	freqs := make([]uint64, wordAlphabet)
+	chunk := opts.bufferSize()
+	for i := 0; i+1 < len(data); i += 2 {
+		if i%chunk == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		freqs[int(data[i])<<8|int(data[i+1])]++
+	}
+	return leavesOf(freqs), nil
+}
+
+// scanParallel is like scan, but splits the size bytes of reader into chunks
+// counted by opts.Workers goroutines.
+func scanParallel(ctx context.Context, reader io.ReaderAt, size int64, opts Options) ([]*Leaf, error) {
+	workers := int64(opts.workers())
+	chunk := (size + workers - 1) / workers
+	if chunk == 0 {
+		chunk = 1
+	}
+
+	chunks := (size + chunk - 1) / chunk
+	parts := make([][256]uint64, chunks)
+	errs := make([]error, chunks)
+
+	var wg sync.WaitGroup
+	for i := range parts {
+		section := io.NewSectionReader(reader, int64(i)*chunk, chunk)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = count(ctx, section, &parts[i], opts)
+		}(i)
+	}
+	wg.Wait()
+
+	var freqs [256]uint64
+	for i := range parts {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for value, freq := range parts[i] {
+			freqs[value] += freq
+		}
+	}
+	return leavesOf(freqs[:]), nil
+}
+
+// count adds the number of occurrences of every byte value in reader to freqs.
+func count(ctx context.Context, reader io.Reader, freqs *[256]uint64, opts Options) error {
+	buf := make([]byte, opts.bufferSize())
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Short reads of pipes and sockets are not the end of the source.
+		n, err := io.ReadFull(reader, buf)
+		addCounts(freqs, buf[:n])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// addCounts adds the number of occurrences of every byte value in p to freqs.
+func addCounts(freqs *[256]uint64, p []byte) {
+	for _, value := range p {
+		freqs[value]++
+	}
+}
+
+// leavesOf returns a leaf for every symbol present in freqs.
+func leavesOf(freqs []uint64) []*Leaf {
+	var leafs []*Leaf
+	for i := 0; i < len(freqs); i++ {
+		freq := freqs[i]
+		if freq > 0 {
+			leafs = append(leafs, &Leaf{
+				Value:     uint16(i),
+				Frequency: freq,
+			})
+		}
+	}
+	return leafs
+}
+
+// addMissing returns leafs with a leaf of the lowest frequency added
+// for every symbol of the alphabet missing from them.
+func addMissing(leafs []*Leaf, wide bool) []*Leaf {
+	freqs := make([]uint64, alphabet(wide))
+	for i := range freqs {
+		freqs[i] = 1
+	}
+	for _, leaf := range leafs {
+		freqs[leaf.Value] = leaf.Frequency
+	}
+	return leavesOf(freqs)
+}
+
+// scaleFrequencies divides leaf frequencies proportionally, so none exceeds limit.
+// Frequencies stay positive, as the tree only depends on their relative values.
+func scaleFrequencies(leafs []*Leaf, limit uint64) {
+	var max uint64
+	for _, leaf := range leafs {
+		if leaf.Frequency > max {
+			max = leaf.Frequency
+		}
+	}
+	if max <= limit {
+		return
+	}
+	factor := (max-1)/limit + 1
+	for _, leaf := range leafs {
+		if leaf.Frequency /= factor; leaf.Frequency == 0 {
+			leaf.Frequency = 1
+		}
+	}
+}
+
+// BuildTree joins leafs into a Huffman tree and returns its root.
+// The two least frequent nodes are joined first, on equal frequencies the most
+// recently joined node goes first, then leafs in the given order. The order
+// is part of the version 1 format, which is rebuilt from frequencies on read.
+// A lone leaf is the root itself. Leafs must have distinct values and positive
+// frequencies, or ErrInvalidLeafs is returned, and there must be some,
+// or ErrNoLeafs is returned.
+func BuildTree(leafs []*Leaf) (*Leaf, error) {
+	if len(leafs) == 0 {
+		return nil, ErrNoLeafs
+	}
+	seen := make(map[uint16]bool, len(leafs))
+	for _, leaf := range leafs {
+		if seen[leaf.Value] || leaf.Frequency == 0 {
+			return nil, ErrInvalidLeafs
+		}
+		seen[leaf.Value] = true
+	}
+
+	if len(leafs) == 1 {
+		return leafs[0], nil
+	}
+
+	queue := make(nodeQueue, len(leafs))
+	for i, leaf := range leafs {
+		queue[i] = node{leaf: leaf, order: i}
+	}
+	heap.Init(&queue)
+
+	for joined := 1; queue.Len() > 1; joined++ {
+		zero := heap.Pop(&queue).(node).leaf
+		zero.Bit = false
+		one := heap.Pop(&queue).(node).leaf
+		one.Bit = true
+		parent := &Leaf{
+			Frequency: zero.Frequency + one.Frequency,
+			Zero:      zero,
+			One:       one,
+		}
+		zero.Parent = parent
+		one.Parent = parent
+		heap.Push(&queue, node{leaf: parent, order: -joined})
+	}
+	return queue[0].leaf, nil
+}
+
+// node is a BuildTree queue entry. Leafs are ordered by their index,
+// joined nodes by negated join number, so they precede older nodes.
+type node struct {
+	leaf  *Leaf
+	order int
+}
+
+// nodeQueue is a min-heap of nodes by frequency, then by order.
+type nodeQueue []node
+
+func (q nodeQueue) Len() int { return len(q) }
+
+func (q nodeQueue) Less(i, j int) bool {
+	if q[i].leaf.Frequency != q[j].leaf.Frequency {
+		return q[i].leaf.Frequency < q[j].leaf.Frequency
+	}
+	return q[i].order < q[j].order
+}
+
+func (q nodeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *nodeQueue) Push(x interface{}) { *q = append(*q, x.(node)) }
+
+func (q *nodeQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// flatTree returns the code of every leaf indexed by its value, each from
+// the root down: the order codes take in the payload and in version 2
+// dictionaries, which readDictionary walks down from the root as they come.
+func flatTree(root *Leaf, leafs []*Leaf, wide bool) [][]bool {
+	dict := make([][]bool, alphabet(wide))
+	if root.Zero == nil && root.One == nil {
+		// A lone symbol is the root itself, code it with a single zero bit.
+		dict[root.Value] = []bool{false}
+		return dict
+	}
+	for _, leaf := range leafs {
+		parent := leaf
+		var path []bool
+		for true {
+			path = append(path, parent.Bit)
+			parent = parent.Parent
+			if parent == root {
+				break
+			}
+		}
+		// Walked up from the leaf, so reverse to get the code from the root down.
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+		dict[leaf.Value] = path
+	}
+	return dict
+}
+
+// readDictionary reads a dictionary and returns the root of its tree.
+// Wide dictionaries hold word mode symbols.
+// Failures are reported as *DecodeError.
+func readDictionary(reader Reader, wide bool, order binary.ByteOrder) (*Leaf, error) {
+	tree, err := readTree(reader, wide, order)
+	if err != nil {
+		return nil, &DecodeError{Phase: PhaseDictionary, Offset: reader.BitsRead(), Err: err}
+	}
+	return tree, nil
+}
+
+// readTree reads a dictionary of either version and returns the root of its tree.
+func readTree(reader Reader, wide bool, order binary.ByteOrder) (*Leaf, error) {
+	var header struct {
+		Version uint16
+		Count   uint32
+	}
+
+	if err := binary.Read(reader, order, &header); err != nil {
+		return nil, err
+	}
+	// Both versions store every symbol at most once, so a count beyond the
+	// alphabet, 256 for bytes, is garbage which must not drive the loops below.
+	if uint64(header.Count) > uint64(alphabet(wide)) {
+		return nil, ErrCorruptHeader
+	}
+
+	if header.Version == 1 {
+		var leafs []*Leaf
+		var frequency uint32
+		for i := 0; i < int(header.Count); i++ {
+			value, err := readSymbol(reader, wide)
+			if err != nil {
+				return nil, err
+			}
+			if err := binary.Read(reader, order, &frequency); err != nil {
+				return nil, err
+			}
+			leafs = append(leafs, &Leaf{
+				Value:     value,
+				Frequency: uint64(frequency),
+			})
+		}
+		switch len(leafs) {
+		case 0:
+			return &Leaf{}, nil
+		case 1:
+			// Matches the single zero bit flatTree assigns to a lone symbol.
+			return &Leaf{Zero: leafs[0]}, nil
+		}
+		tree, err := BuildTree(leafs)
+		if err != nil {
+			return nil, ErrCorruptDictionary
+		}
+		return tree, nil
+	} else if header.Version == 2 {
+		sizes := make([]uint8, alphabet(wide))
+		for i := 0; i < int(header.Count); i++ {
+			value, err := readSymbol(reader, wide)
+			if err != nil {
+				return nil, err
+			}
+			size, err := reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			sizes[value] = size
+		}
+		// Over-subscribed lengths cannot be a prefix code, whatever the paths.
+		if !ValidCodeLengths(sizes) {
+			return nil, ErrCorruptDictionary
+		}
+		root := &Leaf{}
+		parent := root
+		var path [32]byte // a code is at most 255 bits
+		for i := 0; i < len(sizes); i++ {
+			if size := sizes[i]; size > 0 {
+				if err := reader.ReadBitsInto(path[:], int(size)); err != nil {
+					return nil, err
+				}
+				for c := 0; c < int(size); c++ {
+					if path[c/8]&(0x80>>(c%8)) != 0 {
+						if parent.One == nil {
+							parent.One = &Leaf{}
+						}
+						parent = parent.One
+					} else {
+						if parent.Zero == nil {
+							parent.Zero = &Leaf{}
+						}
+						parent = parent.Zero
+					}
+				}
+				parent.Value = uint16(i)
+				parent = root
+			}
+		}
+		reader.Align()
+		if !validTree(root, int(header.Count)) {
+			return nil, ErrCorruptDictionary
+		}
+		return root, nil
+	} else {
+		return nil, ErrUnsupportedVersion
+	}
+}
+
+// ValidCodeLengths reports whether a prefix code can have codes of the given
+// lengths, that is whether they satisfy the Kraft inequality: the sum of
+// 2^-length over all the lengths is at most 1. Zero lengths stand for
+// symbols without a code and are skipped.
+func ValidCodeLengths(lengths []uint8) bool {
+	var counts [256]int
+	left := 0
+	for _, length := range lengths {
+		if length > 0 {
+			counts[length]++
+			left++
+		}
+	}
+	// Codes still available at the current length. Once there are more
+	// than codes left to assign, the rest cannot run out of them.
+	available := 1
+	for length := 1; length < len(counts) && left > 0; length++ {
+		available = 2*available - counts[length]
+		if available < 0 {
+			return false
+		}
+		left -= counts[length]
+		if available > left {
+			available = left
+		}
+	}
+	return true
+}
+
+// validTree reports whether the tree below root is a complete prefix code
+// of count symbols: every inner node has both children and every symbol
+// has a leaf of its own. A lone symbol hangs off the root's zero branch.
+func validTree(root *Leaf, count int) bool {
+	switch count {
+	case 0:
+		return root.Zero == nil && root.One == nil
+	case 1:
+		leaf := root.Zero
+		return leaf != nil && root.One == nil && leaf.Zero == nil && leaf.One == nil
+	}
+	leafs := 0
+	var walk func(leaf *Leaf) bool
+	walk = func(leaf *Leaf) bool {
+		if leaf.Zero == nil && leaf.One == nil {
+			leafs++
+			return true
+		}
+		if leaf.Zero == nil || leaf.One == nil {
+			return false
+		}
+		return walk(leaf.Zero) && walk(leaf.One)
+	}
+	return walk(root) && leafs == count
+}
+
+// readSymbol reads a dictionary symbol, two bytes wide in word mode.
+func readSymbol(reader io.ByteReader, wide bool) (uint16, error) {
+	value, err := reader.ReadByte()
+	if err != nil || !wide {
+		return uint16(value), err
+	}
+	low, err := reader.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return uint16(value)<<8 | uint16(low), err
+}
+
+// putSymbol appends a dictionary symbol to table, two bytes wide in word mode.
+func putSymbol(table *bytes.Buffer, value uint16, wide bool) {
+	if wide {
+		table.WriteByte(byte(value >> 8))
+	}
+	table.WriteByte(byte(value))
+}
+
+// writeDictionary writes the dictionary in the given format version
+// and returns its size in bytes.
+// Version 1 stores symbol frequencies, the tree is rebuilt from them on read.
+// Version 2 stores symbol code paths and is the default one.
+// Wide dictionaries hold word mode symbols.
+func writeDictionary(version int, leafs []*Leaf, dict [][]bool, wide bool, order binary.ByteOrder, writer Writer) (int, error) {
+	switch version {
+	case 1:
+		return writeFrequencies(leafs, wide, order, writer)
+	case 2:
+		return writePaths(dict, len(leafs), wide, order, writer)
+	default:
+		return 0, ErrUnsupportedVersion
+	}
+}
+
+// writeFrequencies writes the version 1 dictionary and returns its size in bytes.
+// Leafs must be in the order they were passed to BuildTree, with frequencies
+// scaled to fit uint32 by scaleFrequencies.
+func writeFrequencies(leafs []*Leaf, wide bool, order binary.ByteOrder, writer Writer) (int, error) {
+	table := new(bytes.Buffer)
+
+	version := 1
+
+	table.Grow(2 + 4 + 5*len(leafs))
+	if err := binary.Write(table, order, uint16(version)); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(table, order, uint32(len(leafs))); err != nil {
+		return 0, err
+	}
+
+	for _, leaf := range leafs {
+		if leaf.Frequency > math.MaxUint32 {
+			return 0, ErrFrequencyOverflow
+		}
+		putSymbol(table, leaf.Value, wide)
+		if err := binary.Write(table, order, uint32(leaf.Frequency)); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := writer.Write(table.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return table.Len(), nil
+}
+
+// writePaths writes the version 2 dictionary and returns its size in bytes.
+// The dictionary is assembled in memory and written with a single Write.
+func writePaths(dict [][]bool, count int, wide bool, order binary.ByteOrder, writer Writer) (int, error) {
+	table := new(bytes.Buffer)
+	body := new(bytes.Buffer)
+	bitOutput := NewWriter(body)
+
+	version := 2
+
+	table.Grow(2 + 4 + 2*count)
+	if err := binary.Write(table, order, uint16(version)); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(table, order, uint32(count)); err != nil {
+		return 0, err
+	}
+
+	for value, path := range dict {
+		size := len(path)
+		if size == 0 {
+			continue
+		}
+		// The length field is a byte, a longer code would be cut.
+		if size > math.MaxUint8 {
+			return 0, ErrCodeTooLong
+		}
+		putSymbol(table, uint16(value), wide)
+		table.WriteByte(uint8(size))
+		if err := bitOutput.WriteBools(path); err != nil {
+			return 0, err
+		}
+	}
+	if err := bitOutput.Close(); err != nil {
+		return 0, err
+	}
+	table.Write(body.Bytes())
+
+	if _, err := writer.Write(table.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return table.Len(), nil
+}
+
+func readFileSize(reader Reader, order binary.ByteOrder) (uint64, error) {
+	var size uint64
+	if err := binary.Read(reader, order, &size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func writeFileSize(size uint64, order binary.ByteOrder, writer Writer) error {
+	return binary.Write(writer, order, size)
+}
+
+// decompress decodes size symbols, writing word mode symbols as byte pairs,
+// and returns the number of bytes written.
+// Output is collected in chunks of up to opts.BufferSize bytes, each passed
+// to the writer by a single Write, which the aligned writer hands on as is.
+// On a decoding error the symbols decoded before it are still written,
+// and the error is a *DecodeError.
+func decompress(ctx context.Context, tree *Leaf, size uint64, wide bool, reader Reader, writer Writer, opts Options) (uint64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	// A tree of a lone symbol, as BuildTree returns it, is the symbol itself.
+	// Its code is a single zero bit, see flatTree, so it is walked as
+	// the zero child of a root, like readDictionary builds it.
+	if tree.Zero == nil && tree.One == nil {
+		tree = &Leaf{Zero: tree}
+	}
+
+	width := uint64(1)
+	if wide {
+		width = 2
+	}
+	chunk := uint64(opts.bufferSize())
+	if size <= chunk/width {
+		chunk = size * width
+	}
+	buf := make([]byte, 0, chunk)
+
+	var decoded, written uint64
+	for {
+		leaf, err := decodeSymbol(tree, reader)
+		if err != nil {
+			n, _ := writer.Write(buf)
+			written += uint64(n)
+			return written, errors.Join(&DecodeError{Phase: PhasePayload, Offset: reader.BitsRead(), Err: err}, writer.Flush())
+		}
+		if wide {
+			buf = append(buf, byte(leaf.Value>>8), byte(leaf.Value))
+		} else {
+			buf = append(buf, byte(leaf.Value))
+		}
+		decoded++
+		if len(buf) >= cap(buf)-1 || decoded == size {
+			n, err := writer.Write(buf)
+			written += uint64(n)
+			if err != nil {
+				return written, err
+			}
+			buf = buf[:0]
+		}
+		if decoded == size {
+			break
+		}
+		if decoded%uint64(opts.bufferSize()) == 0 {
+			if err := ctx.Err(); err != nil {
+				return written, err
+			}
+			opts.progress(decoded, size)
+		}
+	}
+	if _, err := writer.Align(); err != nil {
+		return written, err
+	}
+	opts.progress(decoded, size)
+	return written, nil
+}
+
+// decodeSymbol walks the tree from root along the bits of reader
+// and returns the leaf it ends at.
+func decodeSymbol(root *Leaf, reader Reader) (*Leaf, error) {
+	leaf := root
+	for {
+		b, err := reader.ReadBool()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		if leaf = leaf.child(b); leaf == nil {
+			return nil, ErrCorruptPayload
+		}
+		if leaf.Zero == nil && leaf.One == nil {
+			return leaf, nil
+		}
+	}
+}
+
+// compress encodes the size bytes of reader, or all of them until EOF if size
+// is 0 as it is not known, and returns the number of bytes the payload takes.
+// The writer is left unaligned, the caller pads the last byte, which
+// Writer.Align does with zero bits.
+func compress(ctx context.Context, dict [][]bool, size uint64, reader Reader, writer Writer, opts Options) (uint64, error) {
+	start := writer.BitsWritten()
+	var processed uint64
+	buf := make([]byte, opts.bufferSize())
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		n, err := io.ReadFull(reader, buf)
+		for i := 0; i < n; i++ {
+			value := buf[i]
+			path := dict[value]
+			if len(path) == 0 {
+				return 0, ErrMissingCode
+			}
+			if err := writer.WriteBools(path); err != nil {
+				return 0, err
+			}
+		}
+		processed += uint64(n)
+		opts.progress(processed, size)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	// The size is written ahead of the payload, a source ending early breaks it.
+	if size > 0 && processed != size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return (writer.BitsWritten() - start + 7) / 8, nil
+}
+
+// compressWords is like compress, but encodes the big-endian byte pairs of data.
+// A trailing odd byte is not encoded.
+func compressWords(ctx context.Context, dict [][]bool, data []byte, writer Writer, opts Options) (uint64, error) {
+	start := writer.BitsWritten()
+	chunk := opts.bufferSize()
+	for i := 0; i+1 < len(data); i += 2 {
+		if i%chunk == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			opts.progress(uint64(i), uint64(len(data)))
+		}
+		path := dict[int(data[i])<<8|int(data[i+1])]
+		if len(path) == 0 {
+			return 0, ErrMissingCode
+		}
+		if err := writer.WriteBools(path); err != nil {
+			return 0, err
+		}
+	}
+	opts.progress(uint64(len(data)), uint64(len(data)))
+	return (writer.BitsWritten() - start + 7) / 8, nil
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// writeCounter counts the writes made to it.
+type writeCounter struct {
+	writes int
+	bytes  int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	w.bytes += len(p)
+	return len(p), nil
+}
+
+func (w *writeCounter) WriteByte(b byte) error {
+	w.writes++
+	w.bytes++
+	return nil
+}
+
+// treeOf builds the tree of the byte frequencies of data
+// and returns it with its leafs and codes.
+func treeOf(t testing.TB, data []byte) (*Leaf, []*Leaf, [][]bool) {
+	t.Helper()
+	var freqs [256]uint64
+	addCounts(&freqs, data)
+	leafs := leavesOf(freqs[:])
+	root, err := BuildTree(leafs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, leafs, flatTree(root, leafs, false)
+}
+
+// allBytes returns every byte value, each repeated a different number of times.
+func allBytes() []byte {
+	var data []byte
+	for i := 0; i < 256; i++ {
+		for j := 0; j <= i%5; j++ {
+			data = append(data, byte(i))
+		}
+	}
+	return data
+}
+
+func TestWriteDictionarySingleWrite(t *testing.T) {
+	_, leafs, dict := treeOf(t, allBytes())
+	for _, version := range []int{1, 2} {
+		var out writeCounter
+		size, err := writeDictionary(version, leafs, dict, false, binary.BigEndian, NewWriter(&out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.writes != 1 || out.bytes != size {
+			t.Errorf("version %d: %d writes of %d bytes, want 1 of %d", version, out.writes, out.bytes, size)
+		}
+	}
+}
+
+func BenchmarkWriteDictionary(b *testing.B) {
+	_, leafs, dict := treeOf(b, allBytes())
+	var out writeCounter
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := writeDictionary(2, leafs, dict, false, binary.BigEndian, NewWriter(&out)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(out.writes)/float64(b.N), "writes/op")
+}
+
+func TestFrequencyOverflow(t *testing.T) {
+	leafs := []*Leaf{
+		{Value: 'a', Frequency: math.MaxUint32 + 1},
+		{Value: 'b', Frequency: 1},
+	}
+	root, err := BuildTree(leafs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict := flatTree(root, leafs, false)
+	var out writeCounter
+	if _, err := writeDictionary(1, leafs, dict, false, binary.BigEndian, NewWriter(&out)); err != ErrFrequencyOverflow {
+		t.Fatalf("got %v, want ErrFrequencyOverflow", err)
+	}
+	if out.bytes != 0 {
+		t.Fatalf("%d bytes written on overflow", out.bytes)
+	}
+
+	// Scaled, the frequencies fit and keep the same tree shape.
+	scaleFrequencies(leafs, math.MaxUint32)
+	if leafs[0].Frequency > math.MaxUint32 || leafs[1].Frequency == 0 {
+		t.Fatalf("scaled to %d and %d", leafs[0].Frequency, leafs[1].Frequency)
+	}
+	if _, err := writeDictionary(1, leafs, dict, false, binary.BigEndian, NewWriter(&out)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanParallel(t *testing.T) {
+	for _, size := range []int{0, 1, 7, 100000} {
+		data := sampleText(size)
+		serial, err := scan(context.Background(), bytes.NewReader(data), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{1, 2, 3, 8, 100} {
+			opts := Options{Workers: workers, BufferSize: 1000}
+			parallel, err := scanParallel(context.Background(), bytes.NewReader(data), int64(size), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(parallel, serial) {
+				t.Errorf("%d bytes, %d workers: parallel histogram differs", size, workers)
+			}
+		}
+	}
+}
+
+func FuzzReadDictionary(f *testing.F) {
+	for _, data := range [][]byte{[]byte("a"), []byte("ab"), sampleText(1000), allBytes()} {
+		_, leafs, dict := treeOf(f, data)
+		for _, version := range []int{1, 2} {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			if _, err := writeDictionary(version, leafs, dict, false, binary.BigEndian, w); err != nil {
+				f.Fatal(err)
+			}
+			w.Close()
+			f.Add(buf.Bytes())
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := readDictionary(NewReader(bytes.NewReader(data)), false, binary.BigEndian)
+		if err != nil {
+			return
+		}
+		// A tree read must lead any bits to some symbol or off the tree, never hang.
+		reader := NewReader(bytes.NewReader(data))
+		leaf := tree
+		for i := 0; i < 512 && leaf != nil; i++ {
+			b, err := reader.ReadBool()
+			if err != nil {
+				break
+			}
+			if b {
+				leaf = leaf.One
+			} else {
+				leaf = leaf.Zero
+			}
+			if leaf != nil && leaf.Zero == nil && leaf.One == nil {
+				leaf = tree
+			}
+		}
+	})
+}
+
+func TestReadDictionaryCount(t *testing.T) {
+	for _, version := range []uint16{1, 2} {
+		for _, count := range []uint32{257, 300, 1 << 31, math.MaxUint32} {
+			header := make([]byte, 6, 16)
+			binary.BigEndian.PutUint16(header, version)
+			binary.BigEndian.PutUint32(header[2:], count)
+			header = append(header, 'a', 1, 0, 0, 0, 0)
+			_, err := readDictionary(NewReader(bytes.NewReader(header)), false, binary.BigEndian)
+			if !errors.Is(err, ErrCorruptHeader) {
+				t.Errorf("version %d, count %d: got %v, want ErrCorruptHeader", version, count, err)
+			}
+		}
+	}
+
+	// A whole archive claiming 300 symbols fails before any of them is read.
+	for _, version := range []int{1, 2} {
+		var archive bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), bytes.NewReader(sampleText(1000)), &archive, Options{DictionaryVersion: version}); err != nil {
+			t.Fatal(err)
+		}
+		corrupt := bytes.Clone(archive.Bytes())
+		binary.BigEndian.PutUint32(corrupt[headerSize+2:], 300)
+		err := Decompress(bytes.NewReader(corrupt), io.Discard)
+		var decodeErr *DecodeError
+		if !errors.Is(err, ErrCorruptHeader) || !errors.As(err, &decodeErr) || decodeErr.Offset != 48 {
+			t.Errorf("version %d, count 300: got %v, want ErrCorruptHeader at bit 48", version, err)
+		}
+	}
+}
+
+func TestDecompressLoneSymbol(t *testing.T) {
+	built, err := BuildTree([]*Leaf{{Value: 'z', Frequency: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tree is told apart by its shape, whatever the frequency.
+	for _, tree := range []*Leaf{built, {Value: 'z'}} {
+		var out bytes.Buffer
+		if err := DecompressPayload(tree, 3, bytes.NewReader([]byte{0}), &out); err != nil {
+			t.Fatalf("frequency %d: %v", tree.Frequency, err)
+		}
+		if out.String() != "zzz" {
+			t.Errorf("frequency %d: got %q, want zzz", tree.Frequency, out.Bytes())
+		}
+	}
+
+	// An empty dictionary has no tree of a lone symbol, so an archive
+	// claiming bytes coded by it is corrupt.
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(nil), &archive); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append(bytes.Clone(archive.Bytes()), 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(corrupt[headerSize+6:], 5)
+	if err := Decompress(bytes.NewReader(corrupt), io.Discard); !errors.Is(err, ErrCorruptPayload) {
+		t.Errorf("empty dictionary coding 5 bytes: got %v, want ErrCorruptPayload", err)
+	}
+}
+
+func TestDecompressFlushError(t *testing.T) {
+	tree, err := BuildTree([]*Leaf{{Value: 'a', Frequency: 1}, {Value: 'b', Frequency: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The payload runs out after 8 symbols, which the buffer of the Writer
+	// then fails to flush: both errors are returned.
+	out := struct{ io.Writer }{&fullWriter{}}
+	err = DecompressPayload(tree, 20, bytes.NewReader([]byte{0}), out)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || !errors.Is(err, errFull) {
+		t.Errorf("got %v, want a DecodeError joined with errFull", err)
+	}
+}
+
+func TestCompressMissingCode(t *testing.T) {
+	_, _, dict := treeOf(t, []byte("abc"))
+	reader := NewReader(bytes.NewReader([]byte("abcd")))
+	_, err := compress(context.Background(), dict, 4, reader, NewWriter(new(bytes.Buffer)), Options{})
+	if err != ErrMissingCode {
+		t.Fatalf("got %v, want ErrMissingCode", err)
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	in := sampleText(8 << 20)
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &archive); err != nil {
+		b.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Decompress(bytes.NewReader(archive.Bytes()), &out); err != nil || !bytes.Equal(out.Bytes(), in) {
+		b.Fatalf("round trip failed: %v", err)
+	}
+
+	b.Run("discard", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(in)))
+		for i := 0; i < b.N; i++ {
+			if err := Decompress(bytes.NewReader(archive.Bytes()), io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// A file is no io.ByteWriter, so it is written through the bufio wrapper.
+	b.Run("file", func(b *testing.B) {
+		dst, err := os.Create(filepath.Join(b.TempDir(), "out"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer dst.Close()
+		b.SetBytes(int64(len(in)))
+		for i := 0; i < b.N; i++ {
+			if _, err = dst.Seek(0, io.SeekStart); err != nil {
+				b.Fatal(err)
+			}
+			if err = Decompress(bytes.NewReader(archive.Bytes()), dst); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDecompressAllocs(t *testing.T) {
+	allocs := func(in []byte) float64 {
+		var archive bytes.Buffer
+		if err := Compress(bytes.NewReader(in), &archive); err != nil {
+			t.Fatal(err)
+		}
+		return testing.AllocsPerRun(5, func() {
+			if err := Decompress(bytes.NewReader(archive.Bytes()), io.Discard); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	// The dictionary and buffers take the same allocations whatever
+	// the size, the decoded bytes none.
+	small, large := allocs(sampleText(1<<10)), allocs(sampleText(1<<20))
+	if large > small {
+		t.Fatalf("%v allocations for 1 MiB, %v for 1 KiB", large, small)
+	}
+}
+
+func TestBuildTreeInvalidLeafs(t *testing.T) {
+	tests := []struct {
+		name  string
+		leafs []*Leaf
+	}{
+		{"duplicate value", []*Leaf{{Value: 'a', Frequency: 2}, {Value: 'b', Frequency: 1}, {Value: 'a', Frequency: 3}}},
+		{"zero frequency", []*Leaf{{Value: 'a', Frequency: 2}, {Value: 'b'}}},
+		{"lone zero frequency", []*Leaf{{Value: 'a'}}},
+	}
+	for _, tt := range tests {
+		if _, err := BuildTree(tt.leafs); err != ErrInvalidLeafs {
+			t.Errorf("%s: got %v, want ErrInvalidLeafs", tt.name, err)
+		}
+	}
+}
+
+func TestValidCodeLengths(t *testing.T) {
+	tests := []struct {
+		lengths []uint8
+		valid   bool
+	}{
+		{nil, true},
+		{[]uint8{0, 0}, true},
+		{[]uint8{1}, true},
+		{[]uint8{1, 1}, true},
+		{[]uint8{1, 2, 3, 3}, true},
+		{[]uint8{2, 0, 2, 2}, true}, // incomplete, but a prefix code
+		{[]uint8{1, 1, 1}, false},
+		{[]uint8{1, 2, 2, 3}, false},
+		{[]uint8{2, 2, 2, 2, 2}, false},
+		{append(bytes.Repeat([]uint8{8}, 256), 8), false},
+		{bytes.Repeat([]uint8{8}, 256), true},
+	}
+	for _, tt := range tests {
+		if got := ValidCodeLengths(tt.lengths); got != tt.valid {
+			t.Errorf("ValidCodeLengths(%v) = %v, want %v", tt.lengths, got, tt.valid)
+		}
+	}
+}
+
+func TestBuildTreeLargeHistogram(t *testing.T) {
+	// Counts of a multi-terabyte source, summing up close to math.MaxUint64.
+	var h Histogram
+	var sum uint64
+	for i := range h {
+		h[i] = math.MaxUint64/256 - uint64(i)<<40
+		sum += h[i]
+	}
+	root, err := buildTreeFromHistogram(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Frequency != sum {
+		t.Fatalf("root frequency %d, want %d", root.Frequency, sum)
+	}
+	// Counts this even give every byte value a code of 8 bits.
+	codes := Codes(root)
+	if len(codes) != 256 {
+		t.Fatalf("%d codes, want 256", len(codes))
+	}
+	for _, code := range codes {
+		if code.Len() != 8 {
+			t.Fatalf("byte %d has a code of %d bits, want 8", code.Symbol, code.Len())
+		}
+	}
+}
+
+func TestFullAlphabet(t *testing.T) {
+	// Every byte value once more than the one before, and one of them
+	// far more frequent than the rest, for codes of very different lengths.
+	var data []byte
+	for i := 0; i < 256; i++ {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, i+1)...)
+	}
+	data = append(data, bytes.Repeat([]byte{'e'}, 1<<16)...)
+	rand.New(rand.NewSource(4)).Shuffle(len(data), func(i, j int) {
+		data[i], data[j] = data[j], data[i]
+	})
+
+	for _, version := range []int{1, 2} {
+		archive := roundTrip(t, data, Options{DictionaryVersion: version})
+		if count := binary.BigEndian.Uint32(archive[headerSize+2:]); count != 256 {
+			t.Errorf("version %d: dictionary count %d, want 256", version, count)
+		}
+		info, err := ReadInfo(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(info.Codes) != 256 {
+			t.Errorf("version %d: %d codes, want 256", version, len(info.Codes))
+		}
+		lengths := make([]uint8, len(info.Codes))
+		for i, code := range info.Codes {
+			lengths[i] = uint8(code.Len())
+		}
+		if !ValidCodeLengths(lengths) {
+			t.Errorf("version %d: code lengths %v are no prefix code", version, lengths)
+		}
+	}
+}
+
+func TestDictionaryRoundTrip(t *testing.T) {
+	inputs := map[string][]byte{
+		"one symbol": []byte("aaaa"),
+		"two":        []byte("abb"),
+		"text":       sampleText(10000),
+		"skewed":     append(bytes.Repeat([]byte{'x'}, 10000), "abcdefghij"...),
+		"all bytes":  allBytes(),
+	}
+	for name, data := range inputs {
+		_, leafs, dict := treeOf(t, data)
+		for _, version := range []int{1, 2} {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			if _, err := writeDictionary(version, leafs, dict, false, binary.BigEndian, w); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			tree, err := readDictionary(NewReader(&buf), false, binary.BigEndian)
+			if err != nil {
+				t.Fatalf("%s, version %d: %v", name, version, err)
+			}
+
+			// The tree read gives every symbol the code it was written with.
+			table := NewCodeTable(tree)
+			for _, leaf := range leafs {
+				if got := table.codes[leaf.Value]; len(got) == 0 || !reflect.DeepEqual(got, dict[leaf.Value]) {
+					t.Errorf("%s, version %d: byte %d has code %v, written %v", name, version, leaf.Value, got, dict[leaf.Value])
+				}
+			}
+		}
+	}
+}
+
+func TestSingleSymbol(t *testing.T) {
+	for _, value := range []byte{0, 'a', 0xff} {
+		for _, n := range []int{1, 2, 1000} {
+			in := bytes.Repeat([]byte{value}, n)
+			for _, opts := range []Options{{DictionaryVersion: 1}, {DictionaryVersion: 2}, {Words: true}, {RLE: true}, {BlockSize: 300}} {
+				roundTrip(t, in, opts)
+			}
+
+			var archive bytes.Buffer
+			if err := Compress(bytes.NewReader(in), &archive); err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewDecoder(bytes.NewReader(archive.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := decodeAll(d); err != nil || !bytes.Equal(got, in) {
+				t.Errorf("%d bytes %#x: decoder gave %d bytes, %v", n, value, len(got), err)
+			}
+		}
+	}
+}
+
+func TestDecompressChunks(t *testing.T) {
+	in := sampleText(100000)
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &archive); err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{16, 1000, 4096, 1 << 20} {
+		opts := Options{BufferSize: size}
+		var out bytes.Buffer
+		if _, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive.Bytes()), &out, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("buffer size %d: output differs", size)
+		}
+
+		// Decoded bytes are written a buffer at a time.
+		var counter writeCounter
+		if _, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive.Bytes()), &counter, opts); err != nil {
+			t.Fatal(err)
+		}
+		if limit := (len(in)+size-2)/(size-1) + 1; counter.writes > limit {
+			t.Errorf("buffer size %d: %d writes, want at most %d", size, counter.writes, limit)
+		}
+	}
+}
+
+func BenchmarkDecompressBufferSize(b *testing.B) {
+	in := sampleText(4 << 20)
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &archive); err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []int{64, 4 << 10, 64 << 10} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				if _, err := DecompressWithOptions(context.Background(), bytes.NewReader(archive.Bytes()), io.Discard, Options{BufferSize: size}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildTree(t *testing.T) {
+	if root, err := BuildTree(nil); root != nil || err != ErrNoLeafs {
+		t.Errorf("no leafs: got %v, %v, want ErrNoLeafs", root, err)
+	}
+
+	single := &Leaf{Value: 'a', Frequency: 7}
+	if root, err := BuildTree([]*Leaf{single}); root != single || err != nil {
+		t.Errorf("single leaf: got %v, %v, want the leaf itself", root, err)
+	}
+
+	leafs := []*Leaf{
+		{Value: 'a', Frequency: 5},
+		{Value: 'b', Frequency: 2},
+		{Value: 'c', Frequency: 1},
+		{Value: 'd', Frequency: 1},
+	}
+	root, err := BuildTree(leafs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Frequency != 9 {
+		t.Errorf("root frequency %d, want 9", root.Frequency)
+	}
+	// The more frequent a symbol, the shorter its code.
+	want := map[uint16]int{'a': 1, 'b': 2, 'c': 3, 'd': 3}
+	codes := Codes(root)
+	if len(codes) != len(want) {
+		t.Fatalf("%d codes, want %d", len(codes), len(want))
+	}
+	for _, code := range codes {
+		if code.Len() != want[code.Symbol] {
+			t.Errorf("%q: code of %d bits, want %d", rune(code.Symbol), code.Len(), want[code.Symbol])
+		}
+	}
+	for _, leaf := range leafs {
+		if leaf.Parent == nil {
+			t.Errorf("%q has no parent", rune(leaf.Value))
+		}
+	}
+}
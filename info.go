@@ -0,0 +1,156 @@
+// Archive metadata, read without decoding the payload.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// Info describes an archive.
+type Info struct {
+	Legacy        bool // archive has no header
+	Encrypted     bool // nothing past the header can be read without the passphrase
+	Authenticated bool
+	RLE           bool
+	Words         bool
+	Digest        bool
+	BitCount      bool  // payloads are followed by their valid bit count
+	Stored        bool  // source is kept as is
+	External      bool  // stream is coded by a dictionary kept apart
+	Static        uint8 // id of the static table coding the stream, 0 if none
+	Footer        bool  // size of the stream follows its payload
+	Permuted      bool  // byte values are renumbered before coding, see Codes
+
+	// Name is the original name of the source, if it was stored.
+	Name string
+
+	// OriginalSize is the number of bytes the archive decompresses to.
+	// With a size footer it is read from the end of the archive.
+	// It is 0 if that is only known after decoding: for a run-length encoded
+	// stream, for an archive written by CompressMany, and for an encrypted archive.
+	OriginalSize uint64
+
+	Blocks  []Block // block index of a block mode archive
+	Entries []Entry // files of a multi-file archive
+	Streams int     // number of sources of an archive written by CompressMany
+
+	// Codes of the dictionary of a single stream archive, or of the one
+	// shared by all the files. Symbols of a permuted stream are renumbered.
+	Codes []Code
+}
+
+// ReadInfo reads the metadata of the archive of the given size: the header,
+// the dictionary and the block index or the file table.
+// Only the beginning of the archive is read, and with a file table also its end,
+// but no payload is decoded.
+func ReadInfo(archive io.ReaderAt, size int64) (Info, error) {
+	in := bufio.NewReader(io.NewSectionReader(archive, 0, size))
+	prefix, _ := in.Peek(len(magic))
+	flags, err := readHeader(in)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Legacy:        !bytes.Equal(prefix, magic[:]),
+		Encrypted:     flags&flagEncrypted != 0,
+		Authenticated: flags&flagAuthenticated != 0,
+		RLE:           flags&flagRLE != 0,
+		Words:         flags&flagWords != 0,
+		Digest:        flags&flagDigest != 0,
+		BitCount:      flags&flagBitCount != 0,
+		Stored:        flags&flagStored != 0,
+		External:      flags&flagExternal != 0,
+		Footer:        flags&flagFooter != 0,
+		Permuted:      flags&flagPermuted != 0,
+	}
+	if info.Name, err = readName(in, flags); err != nil {
+		return Info{}, err
+	}
+	var tree *Leaf
+	if info.Static, tree, err = readStatic(in, flags); err != nil {
+		return Info{}, err
+	}
+	if info.Encrypted {
+		return info, nil
+	}
+
+	order := byteOrder(flags)
+	switch {
+	case flags&flagFiles != 0:
+		if info.Entries, _, err = readEntries(archive, size, order); err != nil {
+			return Info{}, err
+		}
+		for _, entry := range info.Entries {
+			info.OriginalSize += entry.Size
+		}
+		if tree, err = sharedTree(archive, flags); err != nil {
+			return Info{}, err
+		}
+	case flags&flagBlocks != 0:
+		if info.Blocks, err = readBlockIndex(in, order, indexStart(info.Name)); err != nil {
+			return Info{}, err
+		}
+		for _, block := range info.Blocks {
+			info.OriginalSize += block.Size
+		}
+	case flags&flagFooter != 0:
+		if flags&flagStatic == 0 {
+			if tree, err = readDictionary(NewReader(in), false, order); err != nil {
+				return Info{}, err
+			}
+		}
+		end := size
+		if info.Digest {
+			end -= sha256.Size
+		}
+		footer := make([]byte, footerSize)
+		if end < int64(footerSize) {
+			return Info{}, io.ErrUnexpectedEOF
+		}
+		if _, err = archive.ReadAt(footer, end-int64(footerSize)); err != nil {
+			return Info{}, err
+		}
+		info.OriginalSize = order.Uint64(footer)
+	case flags&(flagStored|flagExternal|flagStatic) != 0:
+		var length uint64
+		if err = binary.Read(in, order, &length); err != nil {
+			return Info{}, err
+		}
+		if !info.RLE {
+			info.OriginalSize = length
+		}
+	case flags&flagShared != 0:
+		var count uint32
+		if tree, count, err = readManyHeader(NewReader(in), flags); err != nil {
+			return Info{}, err
+		}
+		info.Streams = int(count)
+	default:
+		reader := NewReader(in)
+		if info.Permuted {
+			if _, err = readPermutation(reader, order); err != nil {
+				return Info{}, err
+			}
+		}
+		if tree, err = readDictionary(reader, info.Words, order); err != nil {
+			return Info{}, err
+		}
+		length, err := readFileSize(reader, order)
+		if err != nil {
+			return Info{}, err
+		}
+		if !info.RLE {
+			info.OriginalSize = length
+		}
+	}
+
+	// An empty dictionary has no codes, not even the one of a lone symbol.
+	if tree != nil && (tree.Zero != nil || tree.One != nil) {
+		info.Codes = Codes(tree)
+	}
+	return info, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+// countingReaderAt counts the bytes read from an io.ReaderAt.
+type countingReaderAt struct {
+	in io.ReaderAt
+	n  int64
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = r.in.ReadAt(p, off)
+	r.n += int64(n)
+	return
+}
+
+func TestReadInfoSkipsPayload(t *testing.T) {
+	in := sampleText(1 << 20)
+	for _, opts := range []Options{{}, {BlockSize: 64 << 10}, {SizeFooter: true}} {
+		var archive bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &archive, opts); err != nil {
+			t.Fatal(err)
+		}
+		counter := &countingReaderAt{in: bytes.NewReader(archive.Bytes())}
+		info, err := ReadInfo(counter, int64(archive.Len()))
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if info.OriginalSize != uint64(len(in)) {
+			t.Errorf("%+v: original size %d, want %d", opts, info.OriginalSize, len(in))
+		}
+		// A buffered read or two of the beginning, and the footer.
+		if counter.n > 16<<10 {
+			t.Errorf("%+v: read %d of %d archive bytes", opts, counter.n, archive.Len())
+		}
+	}
+}
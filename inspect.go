@@ -0,0 +1,120 @@
+// Inspection of Huffman trees, to see how symbols are coded.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Code is the code assigned to a symbol.
+type Code struct {
+	Symbol uint16 // byte value, or byte pair in word mode
+	Bits   string // code bits in the order they are written, as '0' and '1'
+}
+
+// Len returns the code length in bits.
+func (c Code) Len() int {
+	return len(c.Bits)
+}
+
+// Tree scans src and returns the root of its Huffman tree.
+func Tree(src io.Reader) (*Leaf, error) {
+	leafs, err := scan(context.Background(), src, Options{})
+	if err != nil {
+		return nil, err
+	}
+	if len(leafs) == 0 {
+		return &Leaf{}, nil
+	}
+	return BuildTree(leafs)
+}
+
+// Codes returns the codes of the symbols of the tree below root,
+// in the order of their codes.
+func Codes(root *Leaf) []Code {
+	if root.Zero == nil && root.One == nil {
+		// A lone symbol is coded with a single zero bit, see flatTree.
+		return []Code{{Symbol: root.Value, Bits: "0"}}
+	}
+	var codes []Code
+	var walk func(leaf *Leaf, bits string)
+	walk = func(leaf *Leaf, bits string) {
+		if leaf == nil {
+			return
+		}
+		if leaf.Zero == nil && leaf.One == nil {
+			codes = append(codes, Code{Symbol: leaf.Value, Bits: bits})
+			return
+		}
+		walk(leaf.Zero, bits+"0")
+		walk(leaf.One, bits+"1")
+	}
+	walk(root, "")
+	return codes
+}
+
+// CodeTable holds the codes of the byte values of a tree.
+type CodeTable struct {
+	codes [256][]bool
+}
+
+// NewCodeTable returns the codes of the byte values of the tree below root,
+// as Codes assigns them. Symbols of word mode trees are not byte values,
+// so they are left out.
+func NewCodeTable(root *Leaf) *CodeTable {
+	t := &CodeTable{}
+	// An empty tree, as of an empty source, has no codes at all.
+	if root.Zero == nil && root.One == nil && root.Frequency == 0 {
+		return t
+	}
+	for _, code := range Codes(root) {
+		if code.Symbol > math.MaxUint8 {
+			continue
+		}
+		bits := make([]bool, len(code.Bits))
+		for i := range bits {
+			bits[i] = code.Bits[i] == '1'
+		}
+		t.codes[code.Symbol] = bits
+	}
+	return t
+}
+
+// Len returns the length of the code of sym in bits, 0 if it has none.
+func (t *CodeTable) Len(sym byte) int {
+	return len(t.codes[sym])
+}
+
+// Bits returns a copy of the code of sym in the order its bits are written,
+// nil if it has none.
+func (t *CodeTable) Bits(sym byte) []bool {
+	if t.codes[sym] == nil {
+		return nil
+	}
+	return append([]bool(nil), t.codes[sym]...)
+}
+
+// String dumps the tree below the leaf, a node per line indented by depth.
+// Lines show the branch bit, the symbol of leafs and the frequency.
+func (l *Leaf) String() string {
+	b := new(strings.Builder)
+	var dump func(leaf *Leaf, depth int, bit string)
+	dump = func(leaf *Leaf, depth int, bit string) {
+		if leaf == nil {
+			return
+		}
+		b.WriteString(strings.Repeat("  ", depth))
+		b.WriteString(bit)
+		if leaf.Zero == nil && leaf.One == nil {
+			fmt.Fprintf(b, " %q", rune(leaf.Value))
+		}
+		fmt.Fprintf(b, " %d\n", leaf.Frequency)
+		dump(leaf.Zero, depth+1, "0")
+		dump(leaf.One, depth+1, "1")
+	}
+	dump(l, 0, "*")
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCodeTable(t *testing.T) {
+	//   *
+	//  0 'a'
+	//  1 *
+	//    0 'b'
+	//    1 *
+	//      0 'c'
+	//      1 'd'
+	root := &Leaf{
+		Zero: &Leaf{Value: 'a', Frequency: 5},
+		One: &Leaf{
+			Zero: &Leaf{Value: 'b', Frequency: 3},
+			One: &Leaf{
+				Zero: &Leaf{Value: 'c', Frequency: 1},
+				One:  &Leaf{Value: 'd', Frequency: 1},
+			},
+		},
+	}
+	want := map[byte][]bool{
+		'a': {false},
+		'b': {true, false},
+		'c': {true, true, false},
+		'd': {true, true, true},
+	}
+
+	table := NewCodeTable(root)
+	for sym := 0; sym < 256; sym++ {
+		code := want[byte(sym)]
+		if got := table.Bits(byte(sym)); !reflect.DeepEqual(got, code) {
+			t.Errorf("byte %d: bits %v, want %v", sym, got, code)
+		}
+		if got := table.Len(byte(sym)); got != len(code) {
+			t.Errorf("byte %d: length %d, want %d", sym, got, len(code))
+		}
+	}
+
+	// The copy returned is the caller's.
+	table.Bits('a')[0] = true
+	if table.Bits('a')[0] {
+		t.Error("changing the bits returned changed the table")
+	}
+
+	lone := NewCodeTable(&Leaf{Value: 'z', Frequency: 3})
+	if bits := lone.Bits('z'); !reflect.DeepEqual(bits, []bool{false}) {
+		t.Errorf("lone symbol: bits %v, want a single zero", bits)
+	}
+	if empty := NewCodeTable(&Leaf{}); empty.Len(0) != 0 {
+		t.Errorf("empty tree: byte 0 has a code of %d bits", empty.Len(0))
+	}
+}
@@ -1,406 +1,178 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"sort"
-	"time"
+	"path/filepath"
+	"runtime"
 )
 
-type Leaf struct {
-	Value     uint8
-	Frequency int
-	Zero      *Leaf
-	One       *Leaf
-	Bit       bool
-	Parent    *Leaf
-}
-
-const BufferSize = 4096
-
 func main() {
-	fmt.Println("Bee Compress (Go)")
-
-	source1 := "/Users/solkin/Desktop/apps-list.json"
-	source2 := "/Users/solkin/Desktop/apps-list(2).json"
-	output := "/Users/solkin/Desktop/apps-list.bzz"
-	createArchive(source1, output)
-	extractArchive(output, source2)
-}
-
-func extractArchive(source string, output string) {
-	srcFile, err := os.Open(source)
-	if err != nil {
-		panic(err)
-	}
-	outFile, err := os.Create(output)
-	if err != nil {
-		panic(err)
+	cmd, err := parseArgs(os.Args[0], os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		os.Exit(2)
 	}
-	reader := NewReader(srcFile)
-	writer := NewWriter(outFile)
 
-	tree, err := readDictionary(reader)
-	if err != nil {
-		panic(err)
+	if cmd.verbose {
+		fmt.Println("Bee Compress (Go)")
 	}
 
-	size, err := readFileSize(reader)
-	if err != nil {
-		panic(err)
+	switch cmd.name {
+	case "c", "compress":
+		createArchive(cmd.source, cmd.output, cmd.opts, cmd.verbose)
+	case "x", "extract":
+		extractArchive(cmd.source, cmd.output, cmd.opts, cmd.verbose)
+	case "a", "append":
+		appendArchive(cmd.source, cmd.output)
 	}
+}
 
-	if err := decompress(tree, size, reader, writer); err != nil {
-		panic(err)
-	}
+// command is what the command line asks for.
+type command struct {
+	name           string // compress, extract or append, or their first letter
+	source, output string // output is empty if the command line leaves it out
+	verbose        bool
+	opts           Options // Workers, TempDir and Force
+}
 
-	err = srcFile.Close()
-	if err != nil {
-		panic(err)
-	}
-	err = outFile.Close()
-	if err != nil {
-		panic(err)
-	}
+// errUsage is returned by parseArgs when the command line is not understood.
+var errUsage = errors.New("invalid command line")
+
+// parseArgs parses the command line arguments following the program name.
+// The usage is written to output when they are not understood.
+func parseArgs(program string, args []string, output io.Writer) (command, error) {
+	flags := flag.NewFlagSet(program, flag.ContinueOnError)
+	flags.SetOutput(output)
+	verbose := flags.Bool("v", false, "print statistics")
+	force := flags.Bool("f", false, "overwrite existing files on extraction")
+	tempDir := flags.String("tmpdir", "", "directory of temporary files, the output directory by default")
+	threads := flags.Int("threads", runtime.NumCPU(), "number of goroutines used for parallel work, at least 1")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: %s [flags] compress|append <source> <output>\n", program)
+		fmt.Fprintf(output, "       %s [flags] extract <source> [output]\n", program)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return command{}, err
+	}
+
+	cmd := command{
+		name:    flags.Arg(0),
+		source:  flags.Arg(1),
+		output:  flags.Arg(2),
+		verbose: *verbose,
+		opts:    Options{Workers: *threads, TempDir: *tempDir, Force: *force},
+	}
+	known := false
+	switch cmd.name {
+	case "c", "compress", "a", "append":
+		known = flags.NArg() == 3
+	// Extraction can name the output after the source stored in the archive.
+	case "x", "extract":
+		known = flags.NArg() == 2 || flags.NArg() == 3
+	}
+	if *threads < 1 || !known {
+		flags.Usage()
+		return command{}, errUsage
+	}
+	return cmd, nil
 }
 
-func createArchive(source string, output string) {
-	leafs, err := scan(source)
-	if err != nil {
-		panic(err)
+// extractArchive decompresses source to output. Without output, a multi-file
+// archive is extracted into the current directory and a single file one
+// to the name stored in it.
+func extractArchive(source string, output string, opts Options, verbose bool) {
+	// A multi-file archive is extracted into the output directory.
+	dir := output
+	if dir == "" {
+		dir = "."
 	}
-
-	tree := buildTree(leafs)
-	dict := flatTree(tree, leafs)
-
-	srcFile, err := os.Open(source)
-	if err != nil {
-		panic(err)
+	err := ExtractFilesWithOptions(source, dir, opts)
+	if err == nil {
+		return
 	}
-	outFile, err := os.Create(output)
-	if err != nil {
+	if !errors.Is(err, ErrNotFilesArchive) {
 		panic(err)
 	}
-	reader := NewReader(srcFile)
-	writer := NewWriter(outFile)
 
-	err = writeDictionary(dict, len(leafs), writer)
+	srcFile, err := os.Open(source)
 	if err != nil {
 		panic(err)
 	}
+	defer srcFile.Close()
 
-	err = writeFileSize(srcFile, writer)
-	if err != nil {
-		panic(err)
+	if output == "" {
+		if output, err = storedName(srcFile); err != nil {
+			panic(err)
+		}
 	}
-
-	err = compress(dict, reader, writer)
-	if err != nil {
-		panic(err)
+	if !opts.Force {
+		if err = checkOutput(output); err != nil {
+			panic(err)
+		}
 	}
 
-	err = srcFile.Close()
+	var stats Stats
+	err = writeAtomically(output, opts.TempDir, func(outFile *os.File) (err error) {
+		stats, err = DecompressWithOptions(context.Background(), srcFile, outFile, opts)
+		return
+	})
 	if err != nil {
 		panic(err)
 	}
-	err = outFile.Close()
-	if err != nil {
-		panic(err)
+
+	if verbose {
+		fmt.Println("size: ", stats.OriginalSize)
+		fmt.Printf("decompress time: %d msec\n", stats.Elapsed.Milliseconds())
 	}
 }
 
-func scan(path string) ([]*Leaf, error) {
-	start := time.Now().UnixNano()
-
-	file, err := os.Open(path)
+// storedName returns the name of the source stored in the archive.
+func storedName(archive *os.File) (string, error) {
+	stat, err := archive.Stat()
 	if err != nil {
-		return nil, err
-	}
-
-	freqs := make([]int, 256)
-
-	unique := 0
-
-	buf := make([]byte, BufferSize)
-	for {
-		n, err := file.Read(buf)
-		if err != nil {
-			break
-		}
-		for i := 0; i < n; i++ {
-			value := buf[i]
-			if freqs[value] == 0 {
-				unique++
-			}
-			freqs[value]++
-		}
+		return "", err
 	}
-
-	err = file.Close()
+	info, err := ReadInfo(archive, stat.Size())
 	if err != nil {
-		return nil, err
-	}
-
-	leafs := make([]*Leaf, unique)
-	l := 0
-	for i := 0; i < len(freqs); i++ {
-		freq := freqs[i]
-		if freq > 0 {
-			leafs[l] = &Leaf{
-				Value:     uint8(i),
-				Frequency: freq,
-			}
-			l++
-		}
+		return "", err
 	}
-
-	fmt.Printf("scan time: %d msec\n", (time.Now().UnixNano()-start)/1000000)
-
-	return leafs, nil
-}
-
-func buildTree(leafs []*Leaf) []*Leaf {
-	tree := make([]*Leaf, len(leafs))
-	copy(tree, leafs)
-
-	for len(tree) > 1 {
-		sort.SliceStable(tree, func(i, j int) bool {
-			return tree[i].Frequency < tree[j].Frequency
-		})
-		zero := tree[0]
-		zero.Bit = false
-		one := tree[1]
-		one.Bit = true
-		parent := &Leaf{
-			Frequency: zero.Frequency + one.Frequency,
-			Zero:      zero,
-			One:       one,
-		}
-		zero.Parent = parent
-		one.Parent = parent
-		tree[1] = parent
-		tree = tree[1:]
+	if info.Name == "" {
+		return "", ErrNoName
 	}
-	return tree
+	return info.Name, nil
 }
 
-func flatTree(tree []*Leaf, leafs []*Leaf) [256][]bool {
-	root := tree[0]
-	var dict [256][]bool
-	for _, leaf := range leafs {
-		parent := leaf
-		var path []bool
-		for true {
-			path = append(path, parent.Bit)
-			parent = parent.Parent
-			if parent == root {
-				break
-			}
-		}
-		dict[leaf.Value] = path
-	}
-	return dict
-}
-
-func readDictionary(reader Reader) (*Leaf, error) {
-	var header struct {
-		Version uint16
-		Count   uint32
-	}
-
-	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
-		return nil, err
-	}
-
-	if header.Version == 1 {
-		leafs := make([]*Leaf, header.Count)
-		var value uint8
-		var frequency uint32
-		for i := 0; i < int(header.Count); i++ {
-			if err := binary.Read(reader, binary.BigEndian, &value); err != nil {
-				return nil, err
-			}
-			if err := binary.Read(reader, binary.BigEndian, &frequency); err != nil {
-				return nil, err
-			}
-			leafs[i] = &Leaf{
-				Value:     value,
-				Frequency: int(frequency),
-			}
-		}
-		return buildTree(leafs)[0], nil
-	} else if header.Version == 2 {
-		var sizes [256]uint8
-		for i := 0; i < int(header.Count); i++ {
-			var value uint8
-			var size uint8
-			if err := binary.Read(reader, binary.BigEndian, &value); err != nil {
-				return nil, err
-			}
-			if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
-				return nil, err
-			}
-			sizes[value] = size
-		}
-		root := &Leaf{}
-		parent := root
-		for i := 0; i < len(sizes); i++ {
-			if size := sizes[i]; size > 0 {
-				for c := 0; c < int(size); c++ {
-					bit, err := reader.ReadBool()
-					if err != nil {
-						return nil, err
-					}
-					if bit {
-						if parent.One == nil {
-							parent.One = &Leaf{}
-						}
-						parent = parent.One
-					} else {
-						if parent.Zero == nil {
-							parent.Zero = &Leaf{}
-						}
-						parent = parent.Zero
-					}
-				}
-				parent.Value = uint8(i)
-				parent = root
-			}
-		}
-		reader.Align()
-		return root, nil
-	} else {
-		panic(fmt.Sprintf("Unsupported archive verision %d", header.Version))
-	}
-}
-
-func writeDictionary(dict [256][]bool, count int, writer Writer) error {
-	body := new(bytes.Buffer)
-	bitOutput := NewWriter(body)
-
-	version := 2
-
-	if err := binary.Write(writer, binary.BigEndian, uint16(version)); err != nil {
-		return err
-	}
-	if err := binary.Write(writer, binary.BigEndian, uint32(count)); err != nil {
-		return err
-	}
-
-	for value, path := range dict {
-		size := len(path)
-		if size == 0 {
-			continue
-		}
-		if err := binary.Write(writer, binary.BigEndian, uint8(value)); err != nil {
-			return err
-		}
-		if err := binary.Write(writer, binary.BigEndian, uint8(size)); err != nil {
-			return err
-		}
-		for i := size - 1; i >= 0; i-- {
-			if err := bitOutput.WriteBool(path[i]); err != nil {
-				return err
-			}
-		}
-	}
-	if err := bitOutput.Close(); err != nil {
-		return err
-	}
-	if _, err := writer.Write(body.Bytes()); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func readFileSize(reader Reader) (uint64, error) {
-	var size uint64
-	if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
-		return 0, err
+func appendArchive(source string, output string) {
+	err := AppendFile(output, source)
+	if err != nil {
+		panic(err)
 	}
-	fmt.Println("size: ", size)
-	return size, nil
 }
 
-func writeFileSize(srcFile *os.File, writer Writer) error {
-	stat, err := srcFile.Stat()
+func createArchive(source string, output string, opts Options, verbose bool) {
+	opts.Name = filepath.Base(source)
+	stats, err := CompressFile(source, output, opts)
 	if err != nil {
-		return err
-	}
-	size := uint64(stat.Size())
-	if err = binary.Write(writer, binary.BigEndian, size); err != nil {
-		return err
+		panic(err)
 	}
-	fmt.Println("size: ", size)
-	return nil
-}
-
-func decompress(tree *Leaf, size uint64, reader Reader, writer Writer) error {
-	start := time.Now().UnixNano()
 
-	var written uint64
-	root := tree
-	var leaf = root
-	for {
-		b, err := reader.ReadBool()
-		if err != nil {
-			panic(err)
-		}
-		var child *Leaf
-		if b {
-			child = leaf.One
-		} else {
-			child = leaf.Zero
-		}
-		if child.Zero != nil || child.One != nil {
-			leaf = child
-		} else {
-			if err := binary.Write(writer, binary.BigEndian, child.Value); err != nil {
-				return err
-			}
-			leaf = root
-			if written++; written == size {
-				break
-			}
-		}
-	}
-	if _, err := writer.Align(); err != nil {
-		return err
+	if stats.Expanded {
+		fmt.Fprintf(os.Stderr, "warning: archive is larger than the source: %d > %d bytes\n", stats.CompressedSize, stats.OriginalSize)
 	}
 
-	fmt.Printf("decompress time: %d msec\n", (time.Now().UnixNano()-start)/1000000)
-	return nil
-}
-
-func compress(dict [256][]bool, reader Reader, writer Writer) error {
-	start := time.Now().UnixNano()
-
-	buf := make([]byte, BufferSize)
-	for {
-		n, err := reader.Read(buf)
-		if err != nil {
-			break
-		}
-		for i := 0; i < n; i++ {
-			value := buf[i]
-			path := dict[value]
-			for j := len(path) - 1; j >= 0; j-- {
-				if err := writer.WriteBool(path[j]); err != nil {
-					panic(err)
-				}
-			}
-		}
-	}
-	if _, err := writer.Align(); err != nil {
-		panic(err)
+	if verbose {
+		fmt.Println("size: ", stats.OriginalSize)
+		fmt.Println("compressed size: ", stats.CompressedSize)
+		fmt.Println("dictionary size: ", stats.DictionarySize)
+		fmt.Println("symbols: ", stats.Symbols)
+		fmt.Printf("ratio: %.3f\n", stats.Ratio())
+		fmt.Printf("compress time: %d msec\n", stats.Elapsed.Milliseconds())
 	}
-	if err := writer.Close(); err != nil {
-		panic(err)
-	}
-
-	fmt.Printf("compress time: %d msec\n", (time.Now().UnixNano()-start)/1000000)
-	return nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExtractStoredName(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(3000)
+	compress := func(name string, opts Options) string {
+		var archive bytes.Buffer
+		if _, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &archive, opts); err != nil {
+			t.Fatal(err)
+		}
+		return writeFile(t, dir, name, archive.Bytes())
+	}
+	named := compress("named.bzz", Options{Name: "notes.txt"})
+
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(out)
+	extractArchive(named, "", Options{Workers: 1}, false)
+	checkFiles(t, out, map[string][]byte{"notes.txt": in})
+
+	// Without a stored name, there is nothing to name the output after.
+	unnamed := compress("unnamed.bzz", Options{})
+	archive, err := os.Open(unnamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	if _, err = storedName(archive); err != ErrNoName {
+		t.Fatalf("got %v, want ErrNoName", err)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want command
+		err  error
+	}{
+		{[]string{"c", "in", "out.bzz"}, command{name: "c", source: "in", output: "out.bzz"}, nil},
+		{[]string{"-v", "-threads", "3", "compress", "in", "out.bzz"}, command{name: "compress", source: "in", output: "out.bzz", verbose: true, opts: Options{Workers: 3}}, nil},
+		{[]string{"-f", "-tmpdir", "/tmp", "x", "in.bzz"}, command{name: "x", source: "in.bzz", opts: Options{Workers: runtime.NumCPU(), TempDir: "/tmp", Force: true}}, nil},
+		{[]string{"-threads=1", "extract", "in.bzz", "out"}, command{name: "extract", source: "in.bzz", output: "out", opts: Options{Workers: 1}}, nil},
+		{[]string{"a", "in", "out.bzz"}, command{name: "a", source: "in", output: "out.bzz"}, nil},
+		{[]string{"-threads", "0", "c", "in", "out.bzz"}, command{}, errUsage},
+		{[]string{"-threads", "-2", "x", "in.bzz"}, command{}, errUsage},
+		{[]string{"c", "in"}, command{}, errUsage},
+		{[]string{"a", "in"}, command{}, errUsage},
+		{[]string{"x"}, command{}, errUsage},
+		{[]string{"list", "in.bzz", "out"}, command{}, errUsage},
+		{[]string{}, command{}, errUsage},
+	}
+	for _, tt := range tests {
+		var usage bytes.Buffer
+		got, err := parseArgs("bee", tt.args, &usage)
+		if err != tt.err {
+			t.Errorf("%q: got %v, want %v", tt.args, err, tt.err)
+			continue
+		}
+		if err != nil {
+			if !strings.Contains(usage.String(), "Usage: bee") {
+				t.Errorf("%q: no usage written", tt.args)
+			}
+			continue
+		}
+		// Without -threads, every CPU is put to work.
+		want := tt.want
+		if want.opts.Workers == 0 {
+			want.opts.Workers = runtime.NumCPU()
+		}
+		if got.name != want.name || got.source != want.source || got.output != want.output || got.verbose != want.verbose ||
+			got.opts.Workers != want.opts.Workers || got.opts.TempDir != want.opts.TempDir || got.opts.Force != want.opts.Force {
+			t.Errorf("%q: got %+v, want %+v", tt.args, got, want)
+		}
+	}
+
+	if _, err := parseArgs("bee", []string{"-threads", "many", "c", "in", "out"}, io.Discard); err == nil {
+		t.Error("-threads many: no error")
+	}
+}
@@ -0,0 +1,106 @@
+// Several sources coded back-to-back by one dictionary.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+)
+
+// CompressMany reads every source of srcs until EOF and writes them
+// to dst as one archive: a single dictionary built for all of them,
+// followed by the sources one after another, each with its own size.
+// Sources are read into memory as they have to be scanned before they are encoded.
+func CompressMany(srcs []io.Reader, dst io.Writer) error {
+	flags := flagShared
+	order := byteOrder(flags)
+
+	sources := make([][]byte, len(srcs))
+	hs := make([]Histogram, len(srcs))
+	for i, src := range srcs {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		sources[i] = data
+		if hs[i], err = ScanHistogram(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	leafs := MergeHistograms(hs...).leafs()
+	dict := make([][]bool, alphabet(false))
+	if len(leafs) > 0 {
+		tree, err := BuildTree(leafs)
+		if err != nil {
+			return err
+		}
+		dict = flatTree(tree, leafs, false)
+	}
+
+	if err := writeHeader(flags, dst); err != nil {
+		return err
+	}
+	writer := NewWriter(dst)
+	if _, err := writeDictionary(DefaultDictionaryVersion, leafs, dict, false, order, writer); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, order, uint32(len(sources))); err != nil {
+		return err
+	}
+	for _, source := range sources {
+		if err := encodePayload(context.Background(), dict, source, flags, writer, Options{}); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// DecompressMany reads an archive written by CompressMany from src
+// and writes every source it holds to the writer of dsts at the same index.
+// The archive has to hold exactly len(dsts) sources, see Info.Streams.
+func DecompressMany(src io.Reader, dsts []io.Writer) error {
+	in, ok := src.(*bufio.Reader)
+	if !ok {
+		in = bufio.NewReader(src)
+	}
+
+	flags, err := readHeader(in)
+	if err != nil {
+		return err
+	}
+	if flags&flagShared == 0 || flags&flagFiles != 0 {
+		return ErrNotManyArchive
+	}
+
+	reader := NewReader(in)
+	tree, count, err := readManyHeader(reader, flags)
+	if err != nil {
+		return err
+	}
+	if int(count) != len(dsts) {
+		return ErrStreamCount
+	}
+	for _, dst := range dsts {
+		if _, err = decodePayload(context.Background(), tree, reader, dst, flags, Options{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readManyHeader reads the dictionary and the number of sources
+// following the header of an archive written by CompressMany.
+func readManyHeader(reader Reader, flags uint32) (*Leaf, uint32, error) {
+	tree, err := readDictionary(reader, false, byteOrder(flags))
+	if err != nil {
+		return nil, 0, err
+	}
+	var count uint32
+	if err = binary.Read(reader, byteOrder(flags), &count); err != nil {
+		return nil, 0, err
+	}
+	return tree, count, nil
+}
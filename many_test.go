@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCompressMany(t *testing.T) {
+	sources := [][]byte{
+		[]byte(`{"id": 1, "name": "first", "tags": ["a", "b"]}`),
+		[]byte(`{"id": 2, "name": "second", "nested": {"ok": false}}`),
+	}
+	var archive bytes.Buffer
+	if err := CompressMany([]io.Reader{bytes.NewReader(sources[0]), bytes.NewReader(sources[1])}, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ReadInfo(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Streams != len(sources) {
+		t.Fatalf("%d streams, want %d", info.Streams, len(sources))
+	}
+
+	outs := make([]bytes.Buffer, len(sources))
+	if err = DecompressMany(bytes.NewReader(archive.Bytes()), []io.Writer{&outs[0], &outs[1]}); err != nil {
+		t.Fatal(err)
+	}
+	for i, source := range sources {
+		if !bytes.Equal(outs[i].Bytes(), source) {
+			t.Errorf("source %d: extracted %q, want %q", i, outs[i].Bytes(), source)
+		}
+	}
+
+	if err = DecompressMany(bytes.NewReader(archive.Bytes()), []io.Writer{io.Discard}); err != ErrStreamCount {
+		t.Errorf("one writer for two sources: got %v, want ErrStreamCount", err)
+	}
+}
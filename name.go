@@ -0,0 +1,50 @@
+// Original name of the source stored after the header.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// nameSize returns the number of bytes the name takes in the archive.
+func nameSize(name string) int {
+	if name == "" {
+		return 0
+	}
+	return 2 + len(name)
+}
+
+// writeName writes the length of the name as uint16 followed by the name.
+func writeName(name string, order binary.ByteOrder, writer io.Writer) error {
+	buf := make([]byte, 2, nameSize(name))
+	order.PutUint16(buf, uint16(len(name)))
+	_, err := writer.Write(append(buf, name...))
+	return err
+}
+
+// readName reads the name following the header if flags tell there is one.
+// Names come from the archive, so only plain file names are accepted.
+func readName(reader *bufio.Reader, flags uint32) (string, error) {
+	if flags&flagName == 0 {
+		return "", nil
+	}
+	var length uint16
+	if err := binary.Read(reader, byteOrder(flags), &length); err != nil {
+		return "", err
+	}
+	name := make([]byte, length)
+	if _, err := io.ReadFull(reader, name); err != nil {
+		return "", err
+	}
+	if !validName(string(name)) {
+		return "", ErrInvalidName
+	}
+	return string(name), nil
+}
+
+// validStoredName reports whether name can be stored by Options.Name.
+func validStoredName(name string) bool {
+	return name == "" || validName(name) && len(name) <= math.MaxUint16
+}
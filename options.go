@@ -0,0 +1,242 @@
+// Options tuning compression and decompression.
+package main
+
+import (
+	"encoding/binary"
+	"runtime"
+)
+
+// ProgressFunc receives the number of processed bytes and the total number
+// of bytes to process. Total is 0 when it is not known in advance.
+// It is never called concurrently by one operation, even one using many workers.
+type ProgressFunc func(processed, total uint64)
+
+// Options configures compression and decompression.
+// The zero value is ready to use.
+//
+// Compression and decompression keep no state shared between calls,
+// so any number of them may run concurrently, sharing Options too,
+// as long as each has its own source and destination.
+type Options struct {
+	// Progress, if not nil, is called after every processed chunk.
+	Progress ProgressFunc
+
+	// BufferSize is the size of the chunks the source is read in.
+	// Zero means BufferSize.
+	BufferSize int
+
+	// DictionaryVersion selects the dictionary format written on compression:
+	// 1 stores symbol frequencies, 2 stores code paths.
+	// Zero means DefaultDictionaryVersion.
+	DictionaryVersion int
+
+	// Workers is the number of goroutines used for parallel work.
+	// Zero means runtime.NumCPU().
+	Workers int
+
+	// BlockSize, if positive, splits the source into blocks of that many bytes
+	// compressed independently, so they can be decompressed without
+	// decoding the whole archive. See DecompressBlock.
+	BlockSize int
+
+	// RLE enables a run-length pre-pass, which helps sources with long runs
+	// of equal bytes, like indentation.
+	RLE bool
+
+	// Words codes pairs of bytes as symbols, which suits text better
+	// at the cost of a much larger dictionary.
+	Words bool
+
+	// Passphrase, if not empty, encrypts the archive on compression
+	// and is required to decrypt it on decompression.
+	Passphrase string
+
+	// Authenticate makes encryption detect any modification of the archive.
+	// The archive is then decrypted in memory before it is decompressed.
+	Authenticate bool
+
+	// Digest appends the SHA-256 digest of the archive to it,
+	// so any modification can be detected by Verify.
+	Digest bool
+
+	// VerifyAfterWrite decompresses the archive in memory right after
+	// it is written and fails compression if it does not give back the source.
+	VerifyAfterWrite bool
+
+	// SharedDictionary codes all the files given to CompressFiles by one
+	// dictionary, which saves space for many similar files.
+	// It is not available in words mode.
+	SharedDictionary bool
+
+	// SampleSize, if positive, builds the dictionary of every stream from
+	// only its first SampleSize bytes, so the rest is not scanned.
+	// Symbols missing from the sample get the longest codes, which makes
+	// the dictionary larger, so it only pays off for large sources.
+	SampleSize int
+
+	// ByteOrder of the multi-byte fields of the archive, like sizes and
+	// the dictionary, written on compression: binary.BigEndian or
+	// binary.LittleEndian. Nil means binary.BigEndian.
+	// It is recorded in the archive, so it is not needed for decompression.
+	ByteOrder binary.ByteOrder
+
+	// Legacy reads the archive on decompression as a legacy one, written
+	// before archives had a header: a single stream with no flags.
+	// The magic is not looked for, so an archive with a header fails.
+	// Archives without the magic are read as legacy ones anyway.
+	Legacy bool
+
+	// MaxOutputSize, if positive, limits the number of bytes decompression
+	// writes, failing with ErrOutputTooLarge as soon as the archive
+	// turns out to hold more, before decoding it if possible.
+	MaxOutputSize uint64
+
+	// CRC32, if not nil, is the IEEE CRC-32 of the source known apart from
+	// the archive. Decompression fails with ErrChecksumMismatch if the data
+	// it wrote does not match it, which is only known once all of it is written.
+	// Compression reports it in Stats.CRC32.
+	CRC32 *uint32
+
+	// BitCount follows every payload by the number of valid bits in its
+	// last byte, so a reader knowing where the payload ends knows it to
+	// the bit without the source size. Decompression still decodes as many
+	// symbols as the size says and only checks the count against them.
+	BitCount bool
+
+	// Store keeps a source which does not compress as it is,
+	// so the archive is never larger than the source but for its header
+	// and size. See Stats.Stored. It does not apply to block mode.
+	Store bool
+
+	// Name, if not empty, is stored in the archive as the original name
+	// of the source, see Info.Name. It has to be a plain file name,
+	// and it is neither encrypted nor authenticated.
+	Name string
+
+	// StaticTable, if not zero, codes the source by the built-in table
+	// of byte frequencies of that id, like StaticEnglish, instead of
+	// a dictionary built for it, so only the id is stored.
+	// That saves the dictionary of small sources at the cost of the ratio.
+	// It is not available in block mode or words mode.
+	StaticTable uint8
+
+	// SizeFooter writes the size of the source, and its CRC-32, after the payload
+	// instead of before it, so the payload is written as the source is read.
+	// With StaticTable there is no dictionary to build first, so the source
+	// is not kept in memory either, which suits sources of unknown size.
+	// Decompression reads the rest of the archive into memory to find the footer.
+	// It is not available in block mode, words mode, with RLE or with Store.
+	SizeFooter bool
+
+	// Permute renumbers the byte values of every stream by their frequency
+	// before coding, storing the permutation ahead of the dictionary.
+	// It is experimental: the codes do not depend on the byte values,
+	// so for now the permutation costs its size without saving any.
+	// It is not available with StaticTable or SizeFooter.
+	Permute bool
+
+	// Chunking splits the source in block mode at boundaries found by a rolling
+	// hash of its content instead of every BlockSize bytes, so inserting or
+	// removing bytes only changes the blocks around the edit, which lets
+	// similar archives share their other blocks. Blocks then average BlockSize
+	// bytes, from a quarter to four times that. It requires BlockSize.
+	Chunking bool
+
+	// TempDir is the directory extracted files are written to before they
+	// are moved in place. Empty means the directory they are extracted to,
+	// which keeps the move a rename on the same file system.
+	TempDir string
+
+	// Force makes extraction replace existing files instead of
+	// failing with ErrOutputExists.
+	Force bool
+}
+
+// DefaultDictionaryVersion is the canonical dictionary format.
+const DefaultDictionaryVersion = 2
+
+// validate checks that the options are usable.
+func (o Options) validate() error {
+	if o.BufferSize < 0 {
+		return ErrInvalidBufferSize
+	}
+	if o.DictionaryVersion < 0 || o.DictionaryVersion > 2 {
+		return ErrUnsupportedVersion
+	}
+	if o.Workers < 0 {
+		return ErrInvalidWorkers
+	}
+	if o.BlockSize < 0 {
+		return ErrInvalidBlockSize
+	}
+	if o.Authenticate && o.Passphrase == "" {
+		return ErrPassphraseRequired
+	}
+	if o.ByteOrder != nil && o.ByteOrder != binary.BigEndian && o.ByteOrder != binary.LittleEndian {
+		return ErrUnsupportedByteOrder
+	}
+	if o.SampleSize < 0 {
+		return ErrInvalidSampleSize
+	}
+	if o.SharedDictionary && o.Words {
+		return ErrSharedWords
+	}
+	if !validStaticTable(o.StaticTable) {
+		return ErrUnknownStaticTable
+	}
+	if o.StaticTable != 0 && (o.BlockSize > 0 || o.Words) {
+		return ErrStaticMode
+	}
+	if o.SizeFooter && (o.BlockSize > 0 || o.Words || o.RLE || o.Store) {
+		return ErrFooterMode
+	}
+	if o.Permute && (o.StaticTable != 0 || o.SizeFooter) {
+		return ErrPermuteMode
+	}
+	if o.Chunking && o.BlockSize == 0 {
+		return ErrChunkingMode
+	}
+	if !validStoredName(o.Name) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// byteOrderFlags returns the header flag for the byte order.
+func (o Options) byteOrderFlags() uint32 {
+	if o.ByteOrder == binary.LittleEndian {
+		return flagLittleEndian
+	}
+	return 0
+}
+
+// bufferSize returns the chunk size to use.
+func (o Options) bufferSize() int {
+	if o.BufferSize == 0 {
+		return BufferSize
+	}
+	return o.BufferSize
+}
+
+// progress reports progress if a callback is set.
+func (o Options) progress(processed, total uint64) {
+	if o.Progress != nil {
+		o.Progress(processed, total)
+	}
+}
+
+// dictionaryVersion returns the dictionary format to write.
+func (o Options) dictionaryVersion() int {
+	if o.DictionaryVersion == 0 {
+		return DefaultDictionaryVersion
+	}
+	return o.DictionaryVersion
+}
+
+// workers returns the number of goroutines to use.
+func (o Options) workers() int {
+	if o.Workers == 0 {
+		return runtime.NumCPU()
+	}
+	return o.Workers
+}
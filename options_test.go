@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		err  error
+	}{
+		{"negative buffer size", Options{BufferSize: -1}, ErrInvalidBufferSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompressWithOptions(context.Background(), bytes.NewReader([]byte("data")), new(bytes.Buffer), tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestOptionsBufferSize(t *testing.T) {
+	in := sampleText(10000)
+	want := roundTrip(t, in, Options{})
+	for _, size := range []int{1, 7, 4096, 1 << 20} {
+		if got := roundTrip(t, in, Options{BufferSize: size}); !bytes.Equal(got, want) {
+			t.Errorf("buffer size %d changed the archive", size)
+		}
+	}
+}
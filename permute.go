@@ -0,0 +1,108 @@
+// Symbol permutation: byte values renumbered by frequency before coding.
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// permutation renumbers the byte values present in data by their frequency,
+// the most frequent first and equal ones by value, followed by the missing
+// byte values in order. It returns the new value of every byte value and
+// the present byte values in their new order, which is all it takes to
+// restore it.
+func permutation(data []byte) (forward [256]byte, ranked []byte) {
+	var freqs [256]uint64
+	addCounts(&freqs, data)
+	for value, freq := range freqs {
+		if freq > 0 {
+			ranked = append(ranked, byte(value))
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return freqs[ranked[i]] > freqs[ranked[j]]
+	})
+	inverse := inversePermutation(ranked)
+	for rank, value := range inverse {
+		forward[value] = byte(rank)
+	}
+	return forward, ranked
+}
+
+// inversePermutation returns the byte value of every new value,
+// given the present byte values in their new order.
+func inversePermutation(ranked []byte) (inverse [256]byte) {
+	var seen [256]bool
+	for rank, value := range ranked {
+		inverse[rank] = value
+		seen[value] = true
+	}
+	rank := len(ranked)
+	for value := range seen {
+		if !seen[value] {
+			inverse[rank] = byte(value)
+			rank++
+		}
+	}
+	return inverse
+}
+
+// permute returns data with every byte replaced by its value in table.
+func permute(data []byte, table *[256]byte) []byte {
+	out := make([]byte, len(data))
+	for i, value := range data {
+		out[i] = table[value]
+	}
+	return out
+}
+
+// writePermutation writes the number of the ranked byte values as uint16
+// followed by them.
+func writePermutation(ranked []byte, order binary.ByteOrder, writer io.Writer) error {
+	buf := make([]byte, 2, 2+len(ranked))
+	order.PutUint16(buf, uint16(len(ranked)))
+	_, err := writer.Write(append(buf, ranked...))
+	return err
+}
+
+// readPermutation reads a permutation written by writePermutation
+// and returns the byte value of every new value.
+func readPermutation(reader io.Reader, order binary.ByteOrder) (*[256]byte, error) {
+	var count uint16
+	if err := binary.Read(reader, order, &count); err != nil {
+		return nil, err
+	}
+	if count > 256 {
+		return nil, ErrCorruptPermutation
+	}
+	ranked := make([]byte, count)
+	if _, err := io.ReadFull(reader, ranked); err != nil {
+		return nil, err
+	}
+	var seen [256]bool
+	for _, value := range ranked {
+		if seen[value] {
+			return nil, ErrCorruptPermutation
+		}
+		seen[value] = true
+	}
+	inverse := inversePermutation(ranked)
+	return &inverse, nil
+}
+
+// permuteWriter writes every byte written to it replaced by its value in table.
+type permuteWriter struct {
+	out   io.Writer
+	table *[256]byte
+	buf   []byte
+}
+
+// Write implements io.Writer.
+func (w *permuteWriter) Write(p []byte) (int, error) {
+	w.buf = w.buf[:0]
+	for _, value := range p {
+		w.buf = append(w.buf, w.table[value])
+	}
+	return w.out.Write(w.buf)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPermutation(t *testing.T) {
+	for _, in := range [][]byte{nil, []byte("abracadabra"), sampleText(10000), allBytes()} {
+		forward, ranked := permutation(in)
+		var buf bytes.Buffer
+		if err := writePermutation(ranked, binary.BigEndian, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 2+len(ranked) {
+			t.Errorf("%d bytes: permutation of %d values stored in %d bytes", len(in), len(ranked), buf.Len())
+		}
+		inverse, err := readPermutation(&buf, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for value := 0; value < 256; value++ {
+			if inverse[forward[value]] != byte(value) {
+				t.Fatalf("%d bytes: %d is restored as %d", len(in), value, inverse[forward[value]])
+			}
+		}
+		if !bytes.Equal(permute(permute(in, &forward), inverse), in) {
+			t.Errorf("%d bytes: permuting back changed the data", len(in))
+		}
+
+		// The archive stores the permutation right after the header.
+		archive := roundTrip(t, in, Options{Permute: true})
+		stored := archive[headerSize:]
+		if count := int(binary.BigEndian.Uint16(stored)); count != len(ranked) || !bytes.Equal(stored[2:2+count], ranked) {
+			t.Errorf("%d bytes: archive stores another permutation", len(in))
+		}
+	}
+}
+
+func TestReadPermutationCorrupt(t *testing.T) {
+	tests := map[string][]byte{
+		"too many values": {0x01, 0x01},
+		"repeated value":  {0x00, 0x03, 'a', 'b', 'a'},
+	}
+	for name, data := range tests {
+		if _, err := readPermutation(bytes.NewReader(data), binary.BigEndian); err != ErrCorruptPermutation {
+			t.Errorf("%s: got %v, want ErrCorruptPermutation", name, err)
+		}
+	}
+}
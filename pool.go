@@ -0,0 +1,59 @@
+// Pooled Reader and Writer for creating many short-lived ones.
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+var (
+	readerPool = sync.Pool{New: func() interface{} { return &reader{} }}
+	writerPool = sync.Pool{New: func() interface{} { return &writer{} }}
+)
+
+// GetReader is like NewReader, but reuses a Reader returned by PutReader
+// together with its internal buffer.
+func GetReader(in io.Reader) Reader {
+	r := readerPool.Get().(*reader)
+	r.Reset(in)
+	return r
+}
+
+// PutReader returns r to the pool. It must not be used afterwards.
+// Readers not created by this package are ignored.
+func PutReader(r Reader) {
+	if r, ok := r.(*reader); ok {
+		// Drop the references to the source, Reset rebinds the rest.
+		if r.wrapperbr != nil {
+			r.wrapperbr.Reset(nil)
+		}
+		r.in = nil
+		r.cache, r.bits = 0, 0
+		r.count = 0
+		readerPool.Put(r)
+	}
+}
+
+// GetWriter is like NewWriter, but reuses a Writer returned by PutWriter
+// together with its internal buffer.
+func GetWriter(out io.Writer) Writer {
+	w := writerPool.Get().(*writer)
+	w.Reset(out)
+	return w
+}
+
+// PutWriter returns w to the pool. It must not be used afterwards.
+// Cached bits are discarded, so w has to be closed first.
+// Writers not created by this package are ignored.
+func PutWriter(w Writer) {
+	if w, ok := w.(*writer); ok {
+		// Drop the references to the target, Reset rebinds the rest.
+		if w.wrapperbw != nil {
+			w.wrapperbw.Reset(nil)
+		}
+		w.out = nil
+		w.cache, w.bits = 0, 0
+		w.count = 0
+		writerPool.Put(w)
+	}
+}
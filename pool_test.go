@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// TestPoolConcurrent borrows and returns readers and writers from many
+// goroutines, run it with -race.
+func TestPoolConcurrent(t *testing.T) {
+	var want bytes.Buffer
+	if err := writePattern(NewWriter(&want)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				var out bytes.Buffer
+				w := GetWriter(&out)
+				err := writePattern(w)
+				PutWriter(w)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(out.Bytes(), want.Bytes()) {
+					t.Errorf("pooled writer wrote %x, want %x", out.Bytes(), want.Bytes())
+					return
+				}
+
+				r := GetReader(bytes.NewReader(out.Bytes()))
+				for bit := 0; bit < 13; bit++ {
+					if b, err := r.ReadBool(); err != nil || b != (bit%3 == 0) {
+						t.Errorf("pooled reader: bit %d is %v, %v", bit, b, err)
+						return
+					}
+				}
+				PutReader(r)
+			}
+		}()
+	}
+	wg.Wait()
+}
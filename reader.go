@@ -4,6 +4,8 @@ package main
 import (
 	"bufio"
 	"io"
+	"math"
+	"math/bits"
 )
 
 // Reader is the bit reader interface.
@@ -12,46 +14,103 @@ type Reader interface {
 	io.Reader
 
 	// Reader is also an io.ByteReader.
-	// ReadByte reads the next 8 bits and returns them as a byte.
+	// ReadByte reads the next 8 bits and returns them as a byte, the first
+	// one read being the lowest bit, like 8 calls of ReadBool whatever
+	// the number of bits cached before.
 	io.ByteReader
 
 	// ReadBool reads the next bit, and returns true if it is 1.
 	ReadBool() (b bool, err error)
 
+	// ReadBools reads the next n bits, like calling ReadBool n times.
+	// On error the bits read so far are returned.
+	ReadBools(n int) (bits []bool, err error)
+
+	// ReadBitsInto reads the next nbits bits into dst, packing them MSB-first:
+	// the first bit read is the highest bit of dst[0]. The unused low bits
+	// of a partial trailing byte are cleared.
+	// On error dst holds the bytes completed so far.
+	ReadBitsInto(dst []byte, nbits int) error
+
 	// Align aligns the bit stream to a byte boundary,
 	// so next read will read/use data from the next byte.
-	// Returns the number of unread / skipped bits.
-	Align() (skipped byte)
+	// The unread bits of the current byte are discarded: this is meant for
+	// skipping the padding written by Writer.Align, calling it anywhere else
+	// loses data.
+	// Returns the number of discarded bits, in range 0..7.
+	// A strict Reader returns ErrUnexpectedAlign instead when bits are
+	// pending, and keeps them.
+	Align() (skipped byte, err error)
+
+	// Reset discards any cached bits and makes the reader read from in,
+	// reusing the internal buffer if there is one.
+	Reset(in io.Reader)
+
+	// BitsRead returns the number of bits consumed since the reader
+	// was created or reset, discarded alignment bits included.
+	BitsRead() uint64
+
+	// PeekBits returns the next n bits without consuming them, n is up to 64.
+	// The first bit of the stream is the lowest bit of the result.
+	PeekBits(n uint8) (uint64, error)
+
+	// SkipBits consumes the next n bits, skipping whole bytes at once.
+	SkipBits(n uint64) error
 }
 
-// An io.Reader and io.ByteReader at the same time.
-type readerAndByteReader interface {
+// An io.Reader and io.ByteReader able to look ahead and skip, like bufio.Reader.
+type bufferedReader interface {
 	io.Reader
 	io.ByteReader
+	Peek(n int) ([]byte, error)
+	Discard(n int) (discarded int, err error)
 }
 
 // reader is the bit reader implementation.
 type reader struct {
-	in    readerAndByteReader
-	cache byte // unread bits are stored here
-	bits  byte // number of unread bits in cache
+	in        bufferedReader
+	wrapperbr *bufio.Reader // wrapper bufio.Reader if the source does not implement bufferedReader
+	cache     byte          // unread bits are stored here
+	bits      byte          // number of unread bits in cache
+	count     uint64        // number of consumed bits
+	config    bitConfig
 }
 
-// NewReader returns a new Reader using the specified io.Reader as the input (source).
-func NewReader(in io.Reader) Reader {
-	var bin readerAndByteReader
-	bin, ok := in.(readerAndByteReader)
+// NewReader returns a new Reader using the specified io.Reader as the input (source),
+// configured by opts.
+// Unless in is a bufio.Reader, it is wrapped in one, so more bytes may be read
+// from in than the Reader consumes.
+func NewReader(in io.Reader, opts ...BitOption) Reader {
+	r := &reader{config: newBitConfig(opts)}
+	r.Reset(in)
+	return r
+}
+
+// Reset implements Reader.
+func (r *reader) Reset(in io.Reader) {
+	var ok bool
+	r.in, ok = in.(bufferedReader)
 	if !ok {
-		bin = bufio.NewReader(in)
+		if r.wrapperbr == nil && r.config.bufferSize > 0 {
+			r.wrapperbr = bufio.NewReaderSize(in, r.config.bufferSize)
+		} else if r.wrapperbr == nil {
+			r.wrapperbr = bufio.NewReader(in)
+		} else {
+			r.wrapperbr.Reset(in)
+		}
+		r.in = r.wrapperbr
 	}
-	return &reader{in: bin}
+	r.cache, r.bits = 0, 0
+	r.count = 0
 }
 
 // Read implements io.Reader.
 func (r *reader) Read(p []byte) (n int, err error) {
 	// r.bits will be the same after reading 8 bits, so we don't need to update that.
 	if r.bits == 0 {
-		return r.in.Read(p)
+		n, err = r.in.Read(p)
+		r.count += 8 * uint64(n)
+		return
 	}
 
 	for ; n < len(p); n++ {
@@ -67,22 +126,27 @@ func (r *reader) Read(p []byte) (n int, err error) {
 func (r *reader) ReadByte() (b byte, err error) {
 	// r.bits will be the same after reading 8 bits, so we don't need to update that.
 	if r.bits == 0 {
-		return r.in.ReadByte()
+		if b, err = r.in.ReadByte(); err == nil {
+			r.count += 8
+		}
+		return
 	}
 	return r.readUnalignedByte()
 }
 
 // readUnalignedByte reads the next 8 bits which are (may be) unaligned and returns them as a byte.
+// Like ReadBool, it takes bits from the lowest one up: the cached bits are
+// the low bits of the result, the high bits come from the next byte.
 func (r *reader) readUnalignedByte() (b byte, err error) {
 	// r.bits will be the same after reading 8 bits, so we don't need to update that.
 	bits := r.bits
-	b = r.cache << (8 - bits)
-	r.cache, err = r.in.ReadByte()
+	next, err := r.in.ReadByte()
 	if err != nil {
 		return 0, err
 	}
-	b |= r.cache >> bits
-	r.cache &= 1<<bits - 1
+	b = r.cache | next<<bits
+	r.cache = next >> (8 - bits)
+	r.count += 8
 	return
 }
 
@@ -98,11 +162,128 @@ func (r *reader) ReadBool() (b bool, err error) {
 	r.bits--
 	b = (r.cache % 2) != 0
 	r.cache /= 2
+	r.count++
 	return
 }
 
-func (r *reader) Align() (skipped byte) {
+// ReadBools implements Reader.
+func (r *reader) ReadBools(n int) (bits []bool, err error) {
+	if n < 0 {
+		return nil, ErrInvalidBitCount
+	}
+	bits = make([]bool, n)
+	for i := range bits {
+		if r.bits == 0 {
+			if r.cache, err = r.in.ReadByte(); err != nil {
+				return bits[:i], err
+			}
+			r.bits = 8
+		}
+		bits[i] = r.cache&1 != 0
+		r.cache >>= 1
+		r.bits--
+		r.count++
+	}
+	return bits, nil
+}
+
+// ReadBitsInto implements Reader.
+func (r *reader) ReadBitsInto(dst []byte, nbits int) error {
+	if nbits < 0 || nbits > 8*len(dst) {
+		return ErrInvalidBitCount
+	}
+	for i := 0; nbits > 0; i++ {
+		// The stream is LSB-first, so a byte read as is only needs its bits reversed.
+		if nbits >= 8 {
+			b, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			dst[i] = bits.Reverse8(b)
+			nbits -= 8
+			continue
+		}
+		v, err := r.PeekBits(uint8(nbits))
+		if err != nil {
+			return err
+		}
+		if err = r.SkipBits(uint64(nbits)); err != nil {
+			return err
+		}
+		dst[i] = bits.Reverse8(byte(v))
+		nbits = 0
+	}
+	return nil
+}
+
+func (r *reader) Align() (skipped byte, err error) {
+	if r.config.strictAlign && r.bits > 0 {
+		return 0, ErrUnexpectedAlign
+	}
 	skipped = r.bits
 	r.bits = 0 // no need to clear cache, will be overwritten on next read
+	r.count += uint64(skipped)
 	return
 }
+
+// BitsRead implements Reader.
+func (r *reader) BitsRead() uint64 {
+	return r.count
+}
+
+// PeekBits implements Reader.
+func (r *reader) PeekBits(n uint8) (uint64, error) {
+	if n > 64 {
+		return 0, ErrInvalidBitCount
+	}
+	v := uint64(r.cache) & (1<<r.bits - 1)
+	if n > r.bits {
+		buf, err := r.in.Peek(int(n-r.bits+7) / 8)
+		if err != nil {
+			return 0, err
+		}
+		for i, b := range buf {
+			v |= uint64(b) << (uint(r.bits) + 8*uint(i))
+		}
+	}
+	if n < 64 {
+		v &= 1<<n - 1
+	}
+	return v, nil
+}
+
+// SkipBits implements Reader.
+func (r *reader) SkipBits(n uint64) error {
+	if n <= uint64(r.bits) {
+		r.cache >>= n
+		r.bits -= byte(n)
+		r.count += n
+		return nil
+	}
+	n -= uint64(r.bits)
+	r.count += uint64(r.bits)
+	r.bits = 0
+
+	for whole := n / 8; whole > 0; {
+		chunk := whole
+		if chunk > math.MaxInt32 {
+			chunk = math.MaxInt32
+		}
+		discarded, err := r.in.Discard(int(chunk))
+		r.count += 8 * uint64(discarded)
+		if err != nil {
+			return err
+		}
+		whole -= uint64(discarded)
+	}
+
+	if rest := byte(n % 8); rest > 0 {
+		cache, err := r.in.ReadByte()
+		if err != nil {
+			return err
+		}
+		r.cache, r.bits = cache>>rest, 8-rest
+		r.count += uint64(rest)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReaderReset(t *testing.T) {
+	var stream bytes.Buffer
+	if err := writePattern(NewWriter(&stream)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(bytes.NewReader([]byte{0xff, 0xff}))
+	r.ReadBool()
+	r.Reset(bytes.NewReader(stream.Bytes()))
+	if r.BitsRead() != 0 {
+		t.Fatalf("BitsRead = %d after Reset, want 0", r.BitsRead())
+	}
+	for i := 0; i < 13; i++ {
+		if b, err := r.ReadBool(); err != nil || b != (i%3 == 0) {
+			t.Fatalf("bit %d: got %v, %v", i, b, err)
+		}
+	}
+	buf := make([]byte, 7)
+	if _, err := r.Read(buf); err != nil || string(buf) != "pattern" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+}
+
+func TestReaderAlignSkipped(t *testing.T) {
+	for read := 0; read < 8; read++ {
+		r := NewReader(bytes.NewReader([]byte{0xff, 0x5a}))
+		for i := 0; i < read; i++ {
+			r.ReadBool()
+		}
+		skipped, err := r.Align()
+		if want := byte((8 - read) % 8); err != nil || skipped != want {
+			t.Errorf("%d bits read: skipped %d, %v, want %d", read, skipped, err, want)
+		}
+		next := byte(0x5a)
+		if read == 0 {
+			next = 0xff
+		}
+		if b, err := r.ReadByte(); err != nil || b != next {
+			t.Errorf("%d bits read: next byte %#x, %v, want %#x", read, b, err, next)
+		}
+	}
+}
+
+func TestBitsRead(t *testing.T) {
+	r := NewReader(bytes.NewReader(make([]byte, 8)))
+	steps := []struct {
+		read func() error
+		want uint64
+	}{
+		{func() error { _, err := r.ReadBool(); return err }, 1},
+		{func() error { _, err := r.ReadByte(); return err }, 9},
+		{func() error { _, err := r.Read(make([]byte, 2)); return err }, 25},
+		{func() error { _, err := r.Align(); return err }, 32},
+		{func() error { _, err := r.Read(make([]byte, 2)); return err }, 48},
+		{func() error { _, err := r.ReadByte(); return err }, 56},
+	}
+	for i, step := range steps {
+		if err := step.read(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.BitsRead(); got != step.want {
+			t.Fatalf("step %d: BitsRead = %d, want %d", i, got, step.want)
+		}
+	}
+}
+
+func TestPeekBitsAcrossBytes(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{0xb4, 0x3c, 0x01}))
+	for i := 0; i < 5; i++ {
+		r.ReadBool()
+	}
+	// The 3 bits left of 0xb4, then 0x3c, then the lowest bit of 0x01.
+	want := uint64(0xb4>>5) | 0x3c<<3 | 1<<11
+	for i := 0; i < 2; i++ {
+		got, err := r.PeekBits(12)
+		if err != nil || got != want {
+			t.Fatalf("peek %d: got %#x, %v, want %#x", i, got, err, want)
+		}
+	}
+	if r.BitsRead() != 5 {
+		t.Fatalf("peeking consumed bits: BitsRead = %d", r.BitsRead())
+	}
+	if b, err := r.ReadByte(); err != nil || b != byte(want) {
+		t.Fatalf("ReadByte after peek: got %#x, %v, want %#x", b, err, byte(want))
+	}
+	if _, err := r.PeekBits(65); err != ErrInvalidBitCount {
+		t.Fatalf("peek of 65 bits: got %v", err)
+	}
+}
+
+func TestSkipBitsBeyondCache(t *testing.T) {
+	data := []byte{0xff, 0x00, 0x00, 0xf0, 0xaa}
+	r := NewReader(bytes.NewReader(data))
+	r.ReadBool()
+	// 7 cached bits, 16 whole bits and 4 bits of 0xf0.
+	if err := r.SkipBits(27); err != nil {
+		t.Fatal(err)
+	}
+	if r.BitsRead() != 28 {
+		t.Fatalf("BitsRead = %d, want 28", r.BitsRead())
+	}
+	if v, err := r.PeekBits(8); err != nil || v != 0x0f|0x0a<<4 {
+		t.Fatalf("after skipping got %#x, %v", v, err)
+	}
+	if err := r.SkipBits(100); err == nil {
+		t.Fatal("skipping past the end succeeded")
+	}
+}
+
+func TestReadBitsInto(t *testing.T) {
+	bits := benchmarkBits(200)
+	var stream bytes.Buffer
+	w := NewWriter(&stream)
+	if err := w.WriteBools(bits); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads after a few cached bits span the cache and the bytes past it.
+	for offset := 0; offset < 8; offset++ {
+		for _, nbits := range []int{0, 1, 3, 7, 8, 9, 13, 16, 21, 64, 100} {
+			r := NewReader(bytes.NewReader(stream.Bytes()))
+			r.ReadBools(offset)
+			dst := make([]byte, (nbits+7)/8)
+			if err := r.ReadBitsInto(dst, nbits); err != nil {
+				t.Fatalf("offset %d, %d bits: %v", offset, nbits, err)
+			}
+			want := make([]byte, len(dst))
+			for i, bit := range bits[offset : offset+nbits] {
+				if bit {
+					want[i/8] |= 0x80 >> (i % 8)
+				}
+			}
+			if !bytes.Equal(dst, want) {
+				t.Errorf("offset %d, %d bits: read %08b, want %08b", offset, nbits, dst, want)
+			}
+			if r.BitsRead() != uint64(offset+nbits) {
+				t.Errorf("offset %d, %d bits: %d bits read", offset, nbits, r.BitsRead())
+			}
+			if b, err := r.ReadBool(); err != nil || b != bits[offset+nbits] {
+				t.Errorf("offset %d, %d bits: next bit %v, %v", offset, nbits, b, err)
+			}
+		}
+	}
+
+	r := NewReader(bytes.NewReader(stream.Bytes()))
+	if err := r.ReadBitsInto(make([]byte, 1), 9); err != ErrInvalidBitCount {
+		t.Errorf("9 bits into a byte: got %v, want ErrInvalidBitCount", err)
+	}
+}
+
+func TestUnalignedBytes(t *testing.T) {
+	values := allBytes()
+	for offset := 0; offset < 8; offset++ {
+		var stream bytes.Buffer
+		w := NewWriter(&stream)
+		for i := 0; i < offset; i++ {
+			w.WriteBool(i%2 == 1)
+		}
+		// Single bits now and then move the bytes across every offset.
+		for i, value := range values {
+			if err := w.WriteByte(value); err != nil {
+				t.Fatal(err)
+			}
+			if i%17 == 0 {
+				w.WriteBool(true)
+			}
+		}
+		if _, err := w.Write(values); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r := NewReader(&stream)
+		for i := 0; i < offset; i++ {
+			if b, err := r.ReadBool(); err != nil || b != (i%2 == 1) {
+				t.Fatalf("offset %d: bit %d is %v, %v", offset, i, b, err)
+			}
+		}
+		for i, value := range values {
+			b, err := r.ReadByte()
+			if err != nil || b != value {
+				t.Fatalf("offset %d: byte %d is %#x, want %#x, %v", offset, i, b, value, err)
+			}
+			if i%17 == 0 {
+				if bit, err := r.ReadBool(); err != nil || !bit {
+					t.Fatalf("offset %d: bit after byte %d is %v, %v", offset, i, bit, err)
+				}
+			}
+		}
+		got := make([]byte, len(values))
+		if _, err := io.ReadFull(r, got); err != nil || !bytes.Equal(got, values) {
+			t.Fatalf("offset %d: Read gave other bytes, %v", offset, err)
+		}
+	}
+}
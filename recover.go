@@ -0,0 +1,71 @@
+// Recovery of the readable part of a damaged archive.
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+)
+
+// RecoveryError is returned by Recover when the archive is damaged.
+type RecoveryError struct {
+	Recovered uint64 // number of bytes written before the damage
+	Expected  uint64 // number of bytes the archive holds, 0 if not known
+	Err       error  // what stopped decoding
+}
+
+func (e *RecoveryError) Error() string {
+	if e.Expected == 0 {
+		return fmt.Sprintf("recovered %d bytes: %v", e.Recovered, e.Err)
+	}
+	return fmt.Sprintf("recovered %d of %d bytes: %v", e.Recovered, e.Expected, e.Err)
+}
+
+func (e *RecoveryError) Unwrap() error {
+	return e.Err
+}
+
+// Recover decompresses a single stream archive from src to dst like Decompress,
+// but if the payload is damaged or cut short, every byte decoded before
+// the damage is still written to dst, and the error is a *RecoveryError.
+// The number of bytes written is returned either way.
+// A damaged header or dictionary leaves nothing to recover.
+func Recover(src io.Reader, dst io.Writer) (uint64, error) {
+	in, ok := src.(*bufio.Reader)
+	if !ok {
+		in = bufio.NewReader(src)
+	}
+
+	flags, err := readHeader(in)
+	if err != nil {
+		return 0, err
+	}
+	if flags&(flagBlocks|flagFiles|flagShared|flagEncrypted|flagStored|flagExternal|flagStatic|flagFooter|flagPermuted) != 0 {
+		return 0, ErrUnsupportedFlags
+	}
+
+	if _, err = readName(in, flags); err != nil {
+		return 0, err
+	}
+
+	reader := NewReader(in)
+	tree, err := readDictionary(reader, flags&flagWords != 0, byteOrder(flags))
+	if err != nil {
+		return 0, err
+	}
+
+	// The dictionary ends aligned, so the size can be looked at
+	// before decodePayload reads it. Run-length encoded size is not
+	// the size of the output.
+	var expected uint64
+	if size, err := in.Peek(8); err == nil && flags&flagRLE == 0 {
+		expected = byteOrder(flags).Uint64(size)
+	}
+
+	out := &countingWriter{out: dst}
+	if _, err = decodePayload(context.Background(), tree, reader, out, flags, Options{}); err != nil {
+		return out.n, &RecoveryError{Recovered: out.n, Expected: expected, Err: err}
+	}
+	return out.n, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	in := sampleText(20000)
+	var archive bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if n, err := Recover(bytes.NewReader(archive.Bytes()), &out); err != nil || n != uint64(len(in)) || !bytes.Equal(out.Bytes(), in) {
+		t.Fatalf("intact archive: recovered %d bytes, %v", n, err)
+	}
+
+	out.Reset()
+	truncated := archive.Bytes()[:archive.Len()/2]
+	n, err := Recover(bytes.NewReader(truncated), &out)
+	var recovery *RecoveryError
+	if !errors.As(err, &recovery) {
+		t.Fatalf("got %v, want a *RecoveryError", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want it to wrap io.ErrUnexpectedEOF", err)
+	}
+	if n == 0 || n >= uint64(len(in)) || n != uint64(out.Len()) {
+		t.Fatalf("recovered %d bytes, wrote %d of %d", n, out.Len(), len(in))
+	}
+	if !bytes.Equal(out.Bytes(), in[:n]) {
+		t.Errorf("recovered bytes are not the beginning of the source")
+	}
+	if recovery.Recovered != n || recovery.Expected != uint64(len(in)) {
+		t.Errorf("error tells %d of %d bytes, want %d of %d", recovery.Recovered, recovery.Expected, n, len(in))
+	}
+	if !strings.Contains(err.Error(), "of 20000 bytes") {
+		t.Errorf("error %q does not tell the expected size", err)
+	}
+}
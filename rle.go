@@ -0,0 +1,64 @@
+// Run-length pre-pass applied to the source before Huffman coding.
+package main
+
+import "io"
+
+// rleRun is the number of equal bytes after which a repeat count follows.
+const rleRun = 4
+
+// rleEncode replaces runs of equal bytes: after rleRun equal bytes comes
+// a byte telling how many more times the byte repeats, 0 to 255.
+func rleEncode(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		value := data[i]
+		n := 1
+		for i+n < len(data) && data[i+n] == value && n < rleRun+255 {
+			n++
+		}
+		if n >= rleRun {
+			for c := 0; c < rleRun; c++ {
+				out = append(out, value)
+			}
+			out = append(out, byte(n-rleRun))
+		} else {
+			for c := 0; c < n; c++ {
+				out = append(out, value)
+			}
+		}
+		i += n
+	}
+	return out
+}
+
+// rleWriter reverses rleEncode on the fly, writing the restored bytes to out.
+type rleWriter struct {
+	out  io.Writer
+	last byte   // value of the current run
+	run  int    // length of the current run, up to rleRun
+	buf  []byte // restored bytes of the current Write
+}
+
+// Write implements io.Writer.
+func (w *rleWriter) Write(p []byte) (int, error) {
+	w.buf = w.buf[:0]
+	for _, value := range p {
+		if w.run == rleRun {
+			for c := 0; c < int(value); c++ {
+				w.buf = append(w.buf, w.last)
+			}
+			w.run = 0
+			continue
+		}
+		if w.run > 0 && value == w.last {
+			w.run++
+		} else {
+			w.last, w.run = value, 1
+		}
+		w.buf = append(w.buf, value)
+	}
+	if _, err := w.out.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestRLE(t *testing.T) {
+	var runs []byte
+	for _, n := range []int{1, 3, 4, 5, 259, 260, 1000} {
+		runs = append(runs, bytes.Repeat([]byte{byte(n)}, n)...)
+	}
+	inputs := map[string][]byte{
+		"empty": nil,
+		"runs":  runs,
+		"text":  sampleText(5000),
+	}
+	for name, in := range inputs {
+		encoded := rleEncode(in)
+		// One byte at a time, so runs span writes.
+		var out bytes.Buffer
+		w := &rleWriter{out: &out}
+		for i := range encoded {
+			if _, err := w.Write(encoded[i : i+1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("%s: restored %d bytes, want %d", name, out.Len(), len(in))
+		}
+		roundTrip(t, in, Options{RLE: true})
+		roundTrip(t, in, Options{RLE: true, BlockSize: 97})
+	}
+}
+
+func TestRLERatio(t *testing.T) {
+	// Indented lines, like source code or pretty-printed JSON.
+	var in []byte
+	for i, line := range bytes.SplitAfter(sampleText(20000), []byte("\n")) {
+		in = append(in, bytes.Repeat([]byte{' '}, 8*(i%8))...)
+		in = append(in, line...)
+	}
+	compressedSize := func(opts Options) uint64 {
+		stats, err := CompressWithOptions(context.Background(), bytes.NewReader(in), new(bytes.Buffer), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats.CompressedSize
+	}
+	plain, rle := compressedSize(Options{}), compressedSize(Options{RLE: true})
+	if rle >= plain {
+		t.Fatalf("RLE archive is %d bytes, %d without", rle, plain)
+	}
+	t.Logf("%d bytes: %d with RLE, %d without", len(in), rle, plain)
+}
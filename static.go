@@ -0,0 +1,82 @@
+// Static tables: built-in byte frequencies standing in for a dictionary.
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+// Static table ids, see Options.StaticTable.
+const (
+	// StaticEnglish suits English text and JSON holding it.
+	StaticEnglish uint8 = 1
+)
+
+// staticTables are the byte frequencies of the static tables by id.
+// Bytes missing from a table get the lowest frequency, so every source
+// can be coded by any table.
+var staticTables = map[uint8]*[256]uint64{
+	StaticEnglish: {
+		' ': 1500, '\n': 120, '\t': 20,
+		'e': 1020, 't': 730, 'a': 650, 'o': 620, 'i': 560, 'n': 560, 's': 520,
+		'r': 490, 'h': 420, 'l': 330, 'd': 320, 'c': 250, 'u': 230, 'm': 200,
+		'f': 180, 'p': 160, 'g': 160, 'w': 150, 'y': 140, 'b': 120, 'v': 80,
+		'k': 60, 'x': 15, 'j': 12, 'q': 9, 'z': 7,
+		'T': 40, 'A': 30, 'I': 30, 'S': 25, 'C': 20, 'E': 15, 'M': 15, 'N': 15,
+		'B': 12, 'D': 12, 'P': 12, 'R': 12, 'H': 10, 'L': 10, 'O': 10, 'W': 10,
+		'F': 8, 'G': 8, 'U': 6, 'V': 4, 'J': 3, 'K': 3, 'Y': 3, 'Q': 1, 'X': 1, 'Z': 1,
+		'0': 60, '1': 60, '2': 45, '3': 35, '4': 30, '5': 30, '6': 25, '7': 25, '8': 25, '9': 25,
+		'"': 200, ':': 90, ',': 130, '.': 90, '{': 35, '}': 35, '[': 15, ']': 15,
+		'-': 30, '_': 25, '\'': 20, '/': 15, '(': 8, ')': 8, '!': 5, '?': 5,
+		'\\': 4, '@': 3, '#': 2, '&': 3, '%': 2, '+': 3, '=': 3, ';': 4, '*': 2,
+	},
+}
+
+// validStaticTable reports whether id can be used as Options.StaticTable.
+func validStaticTable(id uint8) bool {
+	return id == 0 || staticTables[id] != nil
+}
+
+// staticTree builds the tree of the static table of the given id
+// and returns it with the codes of the bytes.
+func staticTree(id uint8) (*Leaf, [][]bool, error) {
+	table := staticTables[id]
+	if table == nil {
+		return nil, nil, ErrUnknownStaticTable
+	}
+	var freqs [256]uint64
+	for i, freq := range table {
+		freqs[i] = freq + 1
+	}
+	leafs := leavesOf(freqs[:])
+	tree, err := BuildTree(leafs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree, flatTree(tree, leafs, false), nil
+}
+
+// writeStatic writes the id of the static table following the header.
+func writeStatic(id uint8, writer io.Writer) error {
+	_, err := writer.Write([]byte{id})
+	return err
+}
+
+// readStatic reads the id of the static table following the header
+// if flags tell there is one, and returns it with the tree of the table.
+func readStatic(reader *bufio.Reader, flags uint32) (uint8, *Leaf, error) {
+	if flags&flagStatic == 0 {
+		return 0, nil, nil
+	}
+	id, err := reader.ReadByte()
+	if err == io.EOF {
+		return 0, nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, nil, err
+	}
+	tree, _, err := staticTree(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, tree, nil
+}
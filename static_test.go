@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestStaticTable(t *testing.T) {
+	_, dict, err := staticTree(StaticEnglish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := [][]byte{
+		nil,
+		[]byte(`{"message": "Hello, world!", "count": 3}`),
+		sampleText(1000),
+		allBytes(), // bytes missing from the table too
+	}
+	for _, in := range inputs {
+		opts := Options{StaticTable: StaticEnglish}
+		archive := roundTrip(t, in, opts)
+
+		// The header, the table id, the size and the payload, nothing else.
+		bits := 0
+		for _, value := range in {
+			bits += len(dict[value])
+		}
+		if want := headerSize + 1 + 8 + (bits+7)/8; len(archive) != want {
+			t.Errorf("%d bytes: archive is %d bytes, want %d", len(in), len(archive), want)
+		}
+		stats, err := CompressWithOptions(context.Background(), bytes.NewReader(in), &bytes.Buffer{}, opts)
+		if err != nil || stats.DictionarySize != 0 {
+			t.Errorf("%d bytes: dictionary size %d, %v", len(in), stats.DictionarySize, err)
+		}
+	}
+
+	if _, err = CompressWithOptions(context.Background(), bytes.NewReader(nil), &bytes.Buffer{}, Options{StaticTable: 200}); err != ErrUnknownStaticTable {
+		t.Errorf("unknown table: got %v, want ErrUnknownStaticTable", err)
+	}
+}
@@ -0,0 +1,30 @@
+// Store mode: sources which do not compress kept as they are.
+package main
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// writeStored writes the size of data followed by data as is.
+func writeStored(data []byte, order binary.ByteOrder, dst io.Writer) error {
+	if err := binary.Write(dst, order, uint64(len(data))); err != nil {
+		return err
+	}
+	_, err := dst.Write(data)
+	return err
+}
+
+// readStored copies the data written by writeStored to dst
+// and returns the number of bytes copied.
+func readStored(reader io.Reader, order binary.ByteOrder, dst io.Writer) (uint64, error) {
+	var size uint64
+	if err := binary.Read(reader, order, &size); err != nil {
+		return 0, err
+	}
+	n, err := io.CopyN(dst, reader, int64(size))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return uint64(n), err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     []byte
+		stored bool
+	}{
+		{"random", randomBytes(10000), true},
+		{"all bytes", allBytes(), true},
+		{"text", sampleText(10000), false},
+	}
+	for _, tt := range tests {
+		var archive bytes.Buffer
+		stats, err := CompressWithOptions(context.Background(), bytes.NewReader(tt.in), &archive, Options{Store: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Stored != tt.stored {
+			t.Errorf("%s: stored %v, want %v", tt.name, stats.Stored, tt.stored)
+		}
+		// Stored or not, the archive is never larger than the source,
+		// its header and its size.
+		if limit := len(tt.in) + headerSize + 8; archive.Len() > limit {
+			t.Errorf("%s: archive is %d bytes, more than %d", tt.name, archive.Len(), limit)
+		}
+		info, err := ReadInfo(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+		if err != nil || info.Stored != tt.stored {
+			t.Errorf("%s: info tells stored %v, %v", tt.name, info.Stored, err)
+		}
+
+		var out bytes.Buffer
+		if err = Decompress(bytes.NewReader(archive.Bytes()), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), tt.in) {
+			t.Errorf("%s: round trip changed the data", tt.name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Streaming wrappers around Compress and Decompress.
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// CompressWriter is an io.WriteCloser compressing everything written to it.
+// The data is kept in memory and the archive is written to the destination
+// on Close, as the source has to be scanned before it is encoded.
+type CompressWriter struct {
+	dst    io.Writer
+	opts   Options
+	buf    bytes.Buffer
+	closed bool
+}
+
+// NewCompressWriter returns a CompressWriter writing the archive to dst.
+func NewCompressWriter(dst io.Writer, opts Options) *CompressWriter {
+	return &CompressWriter{dst: dst, opts: opts}
+}
+
+// Write implements io.Writer.
+func (w *CompressWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return w.buf.Write(p)
+}
+
+// ReadFrom implements io.ReaderFrom, so io.Copy reads straight into the buffer.
+func (w *CompressWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return w.buf.ReadFrom(r)
+}
+
+// Close compresses the written data to the destination.
+// It does not close the destination.
+func (w *CompressWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	_, err := CompressWithOptions(context.Background(), &w.buf, w.dst, w.opts)
+	w.buf = bytes.Buffer{}
+	return err
+}
+
+// DecompressReader is an io.ReadCloser of the data decompressed from an archive.
+// Read decodes in a separate goroutine, call Close to stop it early.
+type DecompressReader struct {
+	src  io.Reader
+	opts Options
+	pipe *io.PipeReader // set once decoding for Read is started
+	done bool           // set once WriteTo decoded the whole archive
+}
+
+// NewDecompressReader returns a DecompressReader decoding the archive from src.
+func NewDecompressReader(src io.Reader, opts Options) *DecompressReader {
+	return &DecompressReader{src: src, opts: opts}
+}
+
+// Read implements io.Reader.
+func (r *DecompressReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if r.pipe == nil {
+		pr, pw := io.Pipe()
+		r.pipe = pr
+		go func() {
+			_, err := DecompressWithOptions(context.Background(), r.src, pw, r.opts)
+			pw.CloseWithError(err)
+		}()
+	}
+	return r.pipe.Read(p)
+}
+
+// WriteTo implements io.WriterTo, so io.Copy decodes straight into w
+// without the goroutine and the intermediate buffer used by Read.
+func (r *DecompressReader) WriteTo(w io.Writer) (int64, error) {
+	if r.pipe != nil {
+		return io.Copy(w, r.pipe)
+	}
+	if r.done {
+		return 0, nil
+	}
+	r.done = true
+	out := &countingWriter{out: w}
+	_, err := DecompressWithOptions(context.Background(), r.src, out, r.opts)
+	return int64(out.n), err
+}
+
+// Close stops decoding started by Read. It does not close the source.
+func (r *DecompressReader) Close() error {
+	if r.pipe != nil {
+		return r.pipe.Close()
+	}
+	return nil
+}
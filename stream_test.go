@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// writerOnly hides the io.ReaderFrom of a writer, so io.Copy calls Write.
+type writerOnly struct {
+	io.Writer
+}
+
+func TestCompressWriter(t *testing.T) {
+	in := sampleText(20000)
+	var want bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	// io.Copy takes ReadFrom, the wrapped writer the Write loop.
+	var fast, slow bytes.Buffer
+	fastWriter, slowWriter := NewCompressWriter(&fast, Options{}), NewCompressWriter(&slow, Options{})
+	if _, err := io.Copy(fastWriter, bytes.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(writerOnly{slowWriter}, iotest.OneByteReader(bytes.NewReader(in))); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []*CompressWriter{fastWriter, slowWriter} {
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(fast.Bytes(), want.Bytes()) || !bytes.Equal(slow.Bytes(), want.Bytes()) {
+		t.Fatal("streamed archives differ from Compress")
+	}
+	if _, err := fastWriter.Write(in); err != io.ErrClosedPipe {
+		t.Fatalf("write after Close: got %v, want io.ErrClosedPipe", err)
+	}
+}
+
+func TestDecompressReader(t *testing.T) {
+	in := sampleText(20000)
+	archive := roundTrip(t, in, Options{})
+
+	// io.Copy takes WriteTo, the one byte reader the Read loop.
+	var fast, slow bytes.Buffer
+	n, err := io.Copy(&fast, NewDecompressReader(bytes.NewReader(archive), Options{}))
+	if err != nil || n != int64(len(in)) {
+		t.Fatalf("WriteTo: %d bytes, %v", n, err)
+	}
+	if _, err := io.Copy(&slow, iotest.OneByteReader(NewDecompressReader(bytes.NewReader(archive), Options{}))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fast.Bytes(), in) || !bytes.Equal(slow.Bytes(), in) {
+		t.Fatal("decompressed streams differ from the source")
+	}
+}
@@ -10,24 +10,60 @@ import (
 // Must be closed in order to flush cached data.
 // If you can't or don't want to close it, flushing data can also be forced
 // by calling Align().
+//
+// An output which is not an io.ByteWriter is wrapped in a bufio.Writer owned
+// by the Writer, which Align, Close and Flush flush. An output which is an
+// io.ByteWriter, like a bufio.Writer, is written directly and flushing it
+// is left to the caller.
 type Writer interface {
 	// Writer is an io.Writer and io.Closer.
+	// Write continues the bit stream: written after an odd number of bits,
+	// the bytes are unaligned and no cached bit is lost.
+	// Like other writes, it may leave the bytes buffered, see Flush.
 	// Close closes the bit writer, writes out cached bits.
 	// It does not close the underlying io.Writer.
+	// Once it succeeds, closing again with nothing written since does nothing
+	// and returns nil, while a failed Close can be retried: a failed flush
+	// of the buffer the writer wrapped the output in fails it again.
 	io.WriteCloser
 
 	// Writer is also an io.ByteWriter.
-	// WriteByte writes 8 bits.
+	// WriteByte writes 8 bits, the lowest one first, like 8 calls of WriteBool
+	// whatever the number of bits cached before.
 	io.ByteWriter
 
 	// WriteBool writes one bit: 1 if param is true, 0 otherwise.
 	WriteBool(b bool) (err error)
 
+	// WriteBools writes the bits in order, like calling WriteBool for each,
+	// but only touches the output once per completed byte.
+	// On an error, the writer holds the bits up to the last byte written out,
+	// so the bits from the failed byte on can be written again.
+	WriteBools(bits []bool) (err error)
+
 	// Align aligns the bit stream to a byte boundary,
 	// so next write will start/go into a new byte.
-	// If there are cached bits, they are first written to the output.
-	// Returns the number of skipped (unset but still written) bits.
+	// If there are cached bits, they are first written to the output
+	// together with unset padding bits up to the byte boundary.
+	// The padding is always zero, as the cache never holds anything above
+	// the bits written to it, so aligned streams are reproducible bit for bit.
+	// Returns the number of padding bits written, in range 0..7.
 	Align() (skipped byte, err error)
+
+	// Flush writes out the whole bytes buffered by the writer, but unlike
+	// Align it writes no padding: the bits of the current partial byte
+	// stay cached, so the bit stream continues seamlessly.
+	// The output is flushed only if it is the buffer the writer wrapped it in.
+	Flush() (err error)
+
+	// Reset discards any cached bits and makes the writer write to out,
+	// reusing the internal buffer if there is one.
+	// Call Close before Reset to keep the data written so far.
+	Reset(out io.Writer)
+
+	// BitsWritten returns the number of bits written since the writer
+	// was created or reset, alignment padding included.
+	BitsWritten() uint64
 }
 
 // An io.Writer and io.ByteWriter at the same time.
@@ -40,27 +76,53 @@ type writerAndByteWriter interface {
 type writer struct {
 	out       writerAndByteWriter
 	wrapperbw *bufio.Writer // wrapper bufio.Writer if the target does not implement io.ByteWriter
-	cache     byte          // unwritten bits are stored here
+	cache     byte          // unwritten bits are stored here, the bits above them are zero
 	bits      byte          // number of unwritten bits in cache
+	count     uint64        // number of written bits
+	closed    bool          // Close succeeded at closedAt bits
+	closedAt  uint64
+	config    bitConfig
+}
+
+// NewWriter returns a new Writer using the specified io.Writer as the output,
+// configured by opts.
+func NewWriter(out io.Writer, opts ...BitOption) Writer {
+	w := &writer{config: newBitConfig(opts)}
+	w.Reset(out)
+	return w
 }
 
-// NewWriter returns a new Writer using the specified io.Writer as the output.
-func NewWriter(out io.Writer) Writer {
-	w := &writer{}
+// NewWriterSize is like NewWriter, but the buffer out is wrapped in,
+// if it is not an io.ByteWriter, has at least size bytes.
+// Pass a bufio.Writer instead to control the buffer completely.
+func NewWriterSize(out io.Writer, size int) Writer {
+	return NewWriter(out, WithBufferSize(size))
+}
+
+// Reset implements Writer.
+func (w *writer) Reset(out io.Writer) {
 	var ok bool
 	w.out, ok = out.(writerAndByteWriter)
 	if !ok {
-		w.wrapperbw = bufio.NewWriter(out)
+		if w.wrapperbw == nil {
+			w.wrapperbw = bufio.NewWriterSize(out, w.config.bufferSize)
+		} else {
+			w.wrapperbw.Reset(out)
+		}
 		w.out = w.wrapperbw
 	}
-	return w
+	w.cache, w.bits = 0, 0
+	w.count = 0
+	w.closed = false
 }
 
 // Write implements io.Writer.
 func (w *writer) Write(p []byte) (n int, err error) {
 	// w.bits will be the same after writing 8 bits, so we don't need to update that.
 	if w.bits == 0 {
-		return w.out.Write(p)
+		n, err = w.out.Write(p)
+		w.count += 8 * uint64(n)
+		return
 	}
 
 	for i, b := range p {
@@ -76,20 +138,26 @@ func (w *writer) Write(p []byte) (n int, err error) {
 func (w *writer) WriteByte(b byte) (err error) {
 	// w.bits will be the same after writing 8 bits, so we don't need to update that.
 	if w.bits == 0 {
-		return w.out.WriteByte(b)
+		if err = w.out.WriteByte(b); err == nil {
+			w.count += 8
+		}
+		return
 	}
 	return w.writeUnalignedByte(b)
 }
 
 // writeUnalignedByte writes 8 bits which are (may be) unaligned.
+// Like WriteBool, it fills the cache from the lowest bit up: the low bits of b
+// complete the cached byte and its high bits are cached.
 func (w *writer) writeUnalignedByte(b byte) (err error) {
 	// w.bits will be the same after writing 8 bits, so we don't need to update that.
 	bits := w.bits
-	err = w.out.WriteByte(w.cache | b>>bits)
+	err = w.out.WriteByte(w.cache | b<<bits)
 	if err != nil {
 		return
 	}
-	w.cache = (b & (1<<bits - 1)) << bits
+	w.cache = b >> (8 - bits)
+	w.count += 8
 	return
 }
 
@@ -98,6 +166,7 @@ func (w *writer) WriteBool(b bool) (err error) {
 		w.cache |= 1 << (w.bits)
 	}
 	w.bits++
+	w.count++
 
 	if w.bits == 8 {
 		err = w.out.WriteByte(w.cache)
@@ -110,27 +179,69 @@ func (w *writer) WriteBool(b bool) (err error) {
 	return nil
 }
 
+// WriteBools implements Writer.
+func (w *writer) WriteBools(bits []bool) (err error) {
+	cache, n := w.cache, w.bits
+	done := 0 // bits in the bytes written out
+	for i, b := range bits {
+		if b {
+			cache |= 1 << n
+		}
+		if n++; n == 8 {
+			if err = w.out.WriteByte(cache); err != nil {
+				return
+			}
+			w.cache, w.bits = 0, 0
+			w.count += uint64(i + 1 - done)
+			done = i + 1
+			cache, n = 0, 0
+		}
+	}
+	w.cache, w.bits = cache, n
+	w.count += uint64(len(bits) - done)
+	return nil
+}
+
 func (w *writer) Align() (skipped byte, err error) {
+	if w.config.strictAlign && w.bits > 0 {
+		return 0, ErrUnexpectedAlign
+	}
 	if w.bits > 0 {
 		if err = w.out.WriteByte(w.cache); err != nil {
 			return
 		}
 
-		skipped = w.bits
+		skipped = 8 - w.bits
 		w.cache, w.bits = 0, 0
+		w.count += uint64(skipped)
 	}
-	if w.wrapperbw != nil {
+	err = w.Flush()
+	return
+}
+
+// Flush implements Writer.
+func (w *writer) Flush() (err error) {
+	if w.wrapperbw != nil && w.out == w.wrapperbw {
 		err = w.wrapperbw.Flush()
 	}
 	return
 }
 
+// BitsWritten implements Writer.
+func (w *writer) BitsWritten() uint64 {
+	return w.count
+}
+
 // Close implements io.Closer.
 func (w *writer) Close() (err error) {
+	if w.closed && w.closedAt == w.count {
+		return nil
+	}
 	// Make sure cached bits are flushed:
 	if _, err = w.Align(); err != nil {
 		return
 	}
 
+	w.closed, w.closedAt = true, w.count
 	return nil
 }
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// writePattern writes a mix of bits and bytes to w and closes it.
+func writePattern(w Writer) error {
+	for i := 0; i < 13; i++ {
+		if err := w.WriteBool(i%3 == 0); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write([]byte("pattern")); err != nil {
+		return err
+	}
+	if err := w.WriteByte(0xa5); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func TestWriterReset(t *testing.T) {
+	var fresh bytes.Buffer
+	if err := writePattern(NewWriter(&fresh)); err != nil {
+		t.Fatal(err)
+	}
+
+	var discarded, reused bytes.Buffer
+	w := NewWriter(&discarded)
+	// Bits left cached must not leak into the new output.
+	for i := 0; i < 5; i++ {
+		w.WriteBool(true)
+	}
+	w.Reset(&reused)
+	if w.BitsWritten() != 0 {
+		t.Fatalf("BitsWritten = %d after Reset, want 0", w.BitsWritten())
+	}
+	if err := writePattern(w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reused.Bytes(), fresh.Bytes()) {
+		t.Fatalf("reset writer wrote %x, fresh one %x", reused.Bytes(), fresh.Bytes())
+	}
+}
+
+func TestWriterAlignSkipped(t *testing.T) {
+	for cached := 0; cached < 8; cached++ {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		for i := 0; i < cached; i++ {
+			w.WriteBool(true)
+		}
+		skipped, err := w.Align()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := byte((8 - cached) % 8)
+		if skipped != want {
+			t.Errorf("%d cached bits: skipped %d, want %d", cached, skipped, want)
+		}
+		if w.BitsWritten()%8 != 0 {
+			t.Errorf("%d cached bits: %d bits written after Align", cached, w.BitsWritten())
+		}
+		// The padding is the unset high bits of the last byte.
+		if cached > 0 && out.Bytes()[out.Len()-1] != byte(1<<cached-1) {
+			t.Errorf("%d cached bits: last byte %08b", cached, out.Bytes()[out.Len()-1])
+		}
+	}
+}
+
+func TestBitsWritten(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	steps := []struct {
+		write func() error
+		want  uint64
+	}{
+		{func() error { return w.WriteBool(true) }, 1},
+		{func() error { return w.WriteByte(1) }, 9},
+		{func() error { _, err := w.Write([]byte{1, 2}); return err }, 25},
+		{func() error { _, err := w.Align(); return err }, 32},
+		{func() error { _, err := w.Write([]byte{1, 2}); return err }, 48},
+		{func() error { return w.WriteByte(1) }, 56},
+	}
+	for i, step := range steps {
+		if err := step.write(); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.BitsWritten(); got != step.want {
+			t.Fatalf("step %d: BitsWritten = %d, want %d", i, got, step.want)
+		}
+	}
+}
+
+// benchmarkBits returns a pattern of n bits.
+func benchmarkBits(n int) []bool {
+	bits := make([]bool, n)
+	for i := range bits {
+		bits[i] = i%3 == 0 || i%7 == 0
+	}
+	return bits
+}
+
+func TestWriteBools(t *testing.T) {
+	bits := benchmarkBits(1001)
+	for offset := 0; offset < 8; offset++ {
+		var one, many bytes.Buffer
+		w1, wn := NewWriter(&one), NewWriter(&many)
+		for i := 0; i < offset; i++ {
+			w1.WriteBool(true)
+			wn.WriteBool(true)
+		}
+		for _, b := range bits {
+			w1.WriteBool(b)
+		}
+		wn.WriteBools(bits)
+		w1.Close()
+		wn.Close()
+		if !bytes.Equal(one.Bytes(), many.Bytes()) {
+			t.Errorf("%d bits before: WriteBools differs from WriteBool", offset)
+		}
+		if w1.BitsWritten() != wn.BitsWritten() {
+			t.Errorf("%d bits before: %d bits written, want %d", offset, wn.BitsWritten(), w1.BitsWritten())
+		}
+
+		r := NewReader(bytes.NewReader(many.Bytes()))
+		r.ReadBools(offset)
+		got, err := r.ReadBools(len(bits))
+		if err != nil || !reflect.DeepEqual(got, bits) {
+			t.Errorf("%d bits before: ReadBools gave other bits, %v", offset, err)
+		}
+	}
+}
+
+func BenchmarkWriteBools(b *testing.B) {
+	bits := benchmarkBits(4096)
+	w := NewWriter(io.Discard)
+	b.Run("WriteBool", func(b *testing.B) {
+		b.SetBytes(int64(len(bits) / 8))
+		for i := 0; i < b.N; i++ {
+			for _, bit := range bits {
+				w.WriteBool(bit)
+			}
+		}
+	})
+	b.Run("WriteBools", func(b *testing.B) {
+		b.SetBytes(int64(len(bits) / 8))
+		for i := 0; i < b.N; i++ {
+			w.WriteBools(bits)
+		}
+	})
+}
+
+func TestFlushKeepsPartialByte(t *testing.T) {
+	bits := benchmarkBits(22)
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	for _, b := range bits[:13] {
+		w.WriteBool(b)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// The whole byte is out, the other 5 bits wait for more.
+	if out.Len() != 1 {
+		t.Fatalf("%d bytes out after Flush, want 1", out.Len())
+	}
+	for _, b := range bits[13:] {
+		w.WriteBool(b)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewReader(&out).ReadBools(len(bits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, bits) {
+		t.Fatalf("read %v, wrote %v", got, bits)
+	}
+}
+
+func TestWriteAfterBits(t *testing.T) {
+	payload := []byte("mixed")
+	for cached := 1; cached < 8; cached++ {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		for i := 0; i < cached; i++ {
+			w.WriteBool(i%2 == 0)
+		}
+		if _, err := w.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The bytes follow the cached bits right away, not the next byte boundary.
+		if out.Len() != len(payload)+1 {
+			t.Fatalf("%d cached bits: wrote %d bytes, want %d", cached, out.Len(), len(payload)+1)
+		}
+		r := NewReader(&out)
+		for i := 0; i < cached; i++ {
+			if b, err := r.ReadBool(); err != nil || b != (i%2 == 0) {
+				t.Fatalf("%d cached bits: bit %d is %v, %v", cached, i, b, err)
+			}
+		}
+		got := make([]byte, len(payload))
+		if _, err := io.ReadFull(r, got); err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("%d cached bits: read %q, %v", cached, got, err)
+		}
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+// fullWriter takes up to n bytes, then fails every write.
+type fullWriter struct {
+	bytes.Buffer
+	n int
+}
+
+var errFull = errors.New("no space left")
+
+func (w *fullWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.n {
+		return 0, errFull
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *fullWriter) WriteByte(b byte) error {
+	if w.Len() >= w.n {
+		return errFull
+	}
+	return w.Buffer.WriteByte(b)
+}
+
+func TestWriteBoolsFailure(t *testing.T) {
+	bits := make([]bool, 20)
+	for i := range bits {
+		bits[i] = i%3 == 0
+	}
+	out := &fullWriter{n: 1}
+	w := NewWriter(out)
+	w.WriteBool(true)
+	if err := w.WriteBools(bits); err != errFull {
+		t.Fatalf("got %v, want the error of the output", err)
+	}
+	// The byte written out is counted, the bits of the failed one are not.
+	if w.BitsWritten() != 8 {
+		t.Fatalf("%d bits written after the failure, want 8", w.BitsWritten())
+	}
+
+	// Writing the rest again once there is room continues the stream.
+	out.n = 100
+	if err := w.WriteBools(bits[7:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewReader(&out.Buffer).ReadBools(1 + len(bits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]bool{true}, bits...); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got bits %v, want %v", got, want)
+	}
+}
+
+func TestWriterClose(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(writerOnly{&out})
+	if err := writePattern(w); err != nil {
+		t.Fatal(err)
+	}
+	closed := out.Len()
+	if err := w.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if out.Len() != closed {
+		t.Fatalf("second Close wrote %d more bytes", out.Len()-closed)
+	}
+	// Bits written after Close are flushed by the next one.
+	w.WriteBool(true)
+	if err := w.Close(); err != nil || out.Len() != closed+1 {
+		t.Fatalf("Close after more bits: %d more bytes, %v", out.Len()-closed, err)
+	}
+
+	failure := errors.New("disk full")
+	w = NewWriter(failingWriter{failure})
+	w.WriteByte(1)
+	w.WriteBool(true)
+	if err := w.Close(); err != failure {
+		t.Fatalf("failing flush: got %v, want the error of the output", err)
+	}
+	// Nothing got out, so the writer is not closed.
+	if err := w.Close(); err == nil {
+		t.Fatal("second Close after a failing flush succeeded")
+	}
+}